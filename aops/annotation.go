@@ -0,0 +1,324 @@
+package aops
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"strings"
+)
+
+// annotationArg is an argument of AOP id, like `path:"/user/id"` in `@middleware-c(path:"/user/id")`.
+// value is the Go source of argument value, expr is its ast. offset is the offset of name in id.
+// typ is the declared type of argument, it is set by `resolveArgs`. ident is the variable name if
+// name collides with the function, it is set by `hygiene`.
+type annotationArg struct {
+	name   string
+	value  string
+	expr   ast.Expr
+	offset int
+	typ    string
+	ident  string
+}
+
+// varName returns the name of variable which the argument is declared as.
+func (a annotationArg) varName() string {
+	if a.ident != "" {
+		return a.ident
+	}
+
+	return a.name
+}
+
+// injectPlaceholder replaces `@inject` in arguments before parsing, since `@` is not valid in Go.
+// It has the same length as `@inject`, so the offsets of error are not changed.
+const injectPlaceholder = "_inject"
+
+// parseArgs parse the arguments of AOP id. The arguments are a list of `name:value` pairs separated by ',',
+// every value is a Go expression, e.g. string, number, bool, `3*time.Second`, `[]string{"a", "b"}` or
+// `@inject`. The id without `()` has no argument.
+//
+// The error is an *AnnotationError, its Pos is relative to id, the line and column start from 1.
+func parseArgs(id string) ([]annotationArg, error) {
+	start := strings.Index(id, "(")
+	if start < 0 {
+		return nil, nil
+	}
+
+	newErr := func(offset int, format string, a ...interface{}) error {
+		return &AnnotationError{
+			ID:  extractFuncName(id),
+			Pos: token.Position{Offset: offset, Line: 1, Column: offset + 1},
+			Msg: fmt.Sprintf(format, a...),
+		}
+	}
+
+	if !strings.HasSuffix(id, ")") {
+		return nil, newErr(len(id), "missing ')'")
+	}
+
+	// parse the arguments as the elements of a composite literal, `(` is replaced by `T{`.
+	// So the offset of source is the offset of id plus 1.
+	src := []byte("T{" + id[start+1:len(id)-1] + "}")
+	shift := start - 1
+	replaceInject(src)
+
+	fset := token.NewFileSet()
+	expr, err := parser.ParseExprFrom(fset, "", src, 0)
+	if err != nil {
+		if errs, ok := err.(scanner.ErrorList); ok && len(errs) > 0 {
+			return nil, newErr(errs[0].Pos.Offset+shift, "%s", errs[0].Msg)
+		}
+		return nil, newErr(start, "%v", err)
+	}
+
+	lit, ok := expr.(*ast.CompositeLit)
+	if !ok {
+		return nil, newErr(start, "invalid arguments")
+	}
+
+	offset := func(p token.Pos) int {
+		return fset.Position(p).Offset
+	}
+
+	var args []annotationArg
+	names := make(map[string]bool)
+	for _, e := range lit.Elts {
+		kv, ok := e.(*ast.KeyValueExpr)
+		if !ok {
+			return nil, newErr(offset(e.Pos())+shift, "argument should be name:value")
+		}
+
+		key, ok := kv.Key.(*ast.Ident)
+		if !ok {
+			return nil, newErr(offset(kv.Key.Pos())+shift, "argument name should be an identifier")
+		}
+		if names[key.Name] {
+			return nil, newErr(offset(kv.Key.Pos())+shift, "duplicate argument %s", key.Name)
+		}
+		names[key.Name] = true
+
+		value := string(src[offset(kv.Value.Pos()):offset(kv.Value.End())])
+		if value == injectPlaceholder {
+			value = aopInjectLabel
+		}
+
+		args = append(args, annotationArg{
+			name:   key.Name,
+			value:  value,
+			expr:   kv.Value,
+			offset: offset(key.Pos()) + shift,
+		})
+	}
+
+	return args, nil
+}
+
+// resolveArgs check args parsed from id with the declared params, and return the arguments to declare
+// by the order of params. The missing arguments get the default values, the optional argument without
+// default value is declared as the zero value if it has type. The unknown and missing required arguments
+// are errors. If params is empty, args are returned directly.
+//
+// The error is an *AnnotationError, its Pos is relative to id like `parseArgs`.
+func resolveArgs(id string, args []annotationArg, params []Param) ([]annotationArg, error) {
+	if len(params) == 0 {
+		return args, nil
+	}
+
+	newErr := func(offset int, format string, a ...interface{}) error {
+		return &AnnotationError{
+			ID:  extractFuncName(id),
+			Pos: token.Position{Offset: offset, Line: 1, Column: offset + 1},
+			Msg: fmt.Sprintf(format, a...),
+		}
+	}
+
+	given := make(map[string]annotationArg, len(args))
+	for _, a := range args {
+		given[a.name] = a
+	}
+
+	declared := make(map[string]bool, len(params))
+	names := make([]string, 0, len(params))
+	for _, p := range params {
+		declared[p.Name] = true
+		names = append(names, p.Name)
+	}
+	for _, a := range args {
+		if !declared[a.name] {
+			return nil, newErr(a.offset, "unknown argument %s, the valid arguments are %s", a.name, strings.Join(names, ", "))
+		}
+	}
+
+	var result []annotationArg
+	for _, p := range params {
+		a, exist := given[p.Name]
+		switch {
+		case exist:
+			if err := checkArgType(p.Type, a.expr); err != nil {
+				return nil, newErr(a.offset, "argument %s: %v", a.name, err)
+			}
+		case p.Required:
+			return nil, newErr(0, "missing required argument %s", p.Name)
+		case p.Default == aopInjectLabel:
+			a = annotationArg{name: p.Name, value: p.Default}
+		case p.Default != "":
+			expr, err := parser.ParseExpr(p.Default)
+			if err != nil {
+				return nil, newErr(0, "bad default value of %s: %v", p.Name, err)
+			}
+			a = annotationArg{name: p.Name, value: p.Default, expr: expr}
+		case p.Type != "":
+			a = annotationArg{name: p.Name}
+		default:
+			continue
+		}
+
+		a.typ = p.Type
+		result = append(result, a)
+	}
+
+	return result, nil
+}
+
+// CheckParams check the params declared by middleware id. Every param should have a unique name which
+// is a valid identifier, the type and default value should be valid Go source or `@inject`, and the required param
+// can not have default value. The default value of basic type is checked like the argument.
+func CheckParams(id string, params []Param) error {
+	names := make(map[string]bool, len(params))
+	for i, p := range params {
+		newErr := func(format string, a ...interface{}) error {
+			return fmt.Errorf("middleware %s: param[%d] %s: %s", id, i, p.Name, fmt.Sprintf(format, a...))
+		}
+
+		if !token.IsIdentifier(p.Name) {
+			return newErr("name should be an identifier")
+		}
+		if names[p.Name] {
+			return newErr("duplicate name")
+		}
+		names[p.Name] = true
+
+		if p.Type != "" {
+			if _, err := parser.ParseExpr(p.Type); err != nil {
+				return newErr("bad type %q: %v", p.Type, err)
+			}
+		}
+
+		if p.Default == "" {
+			continue
+		}
+		if p.Required {
+			return newErr("required param can not have default value")
+		}
+		if p.Default == aopInjectLabel {
+			continue
+		}
+		expr, err := parser.ParseExpr(p.Default)
+		if err != nil {
+			return newErr("bad default value %q: %v", p.Default, err)
+		}
+		if err := checkArgType(p.Type, expr); err != nil {
+			return newErr("default value: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// checkArgType check whether the literal value can be assigned to typ. Only the basic types and
+// time.Duration are checked, since other types and expressions need type checking of the whole package.
+func checkArgType(typ string, value ast.Expr) error {
+	var kind string
+	switch v := value.(type) {
+	case *ast.BasicLit:
+		kind = v.Kind.String()
+	case *ast.Ident:
+		if v.Name != "true" && v.Name != "false" {
+			return nil
+		}
+		kind = "bool"
+	case *ast.UnaryExpr:
+		if lit, ok := v.X.(*ast.BasicLit); ok && (v.Op == token.SUB || v.Op == token.ADD) {
+			kind = lit.Kind.String()
+		}
+	}
+	if kind == "" {
+		return nil
+	}
+
+	var valid []string
+	switch typ {
+	case "string":
+		valid = []string{"STRING"}
+	case "bool":
+		valid = []string{"bool"}
+	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64", "uintptr",
+		"byte", "rune", "time.Duration":
+		valid = []string{"INT", "CHAR"}
+	case "float32", "float64":
+		valid = []string{"INT", "FLOAT", "CHAR"}
+	case "complex64", "complex128":
+		valid = []string{"INT", "FLOAT", "IMAG", "CHAR"}
+	default:
+		return nil
+	}
+
+	for _, v := range valid {
+		if v == kind {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s value can not be used as %s", strings.ToLower(kind), typ)
+}
+
+// replaceInject replace `@inject` which is not in string or comment with injectPlaceholder.
+func replaceInject(src []byte) {
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	s.Init(file, src, func(token.Position, string) {}, 0)
+
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			return
+		}
+
+		offset := file.Offset(pos)
+		if tok == token.ILLEGAL && lit == "@" && strings.HasPrefix(string(src[offset:]), aopInjectLabel) {
+			src[offset] = '_'
+		}
+	}
+}
+
+// commentPosition get the position of text in the doc comment of fd. Return the position
+// of doc comment if text is not found.
+func commentPosition(fset *token.FileSet, fd *ast.FuncDecl, text string) token.Position {
+	if fd.Doc == nil {
+		return fset.Position(fd.Pos())
+	}
+
+	for _, c := range fd.Doc.List {
+		idx := strings.Index(c.Text, text)
+		if idx < 0 {
+			continue
+		}
+
+		pos := fset.Position(c.Pos())
+		before := c.Text[:idx]
+		if n := strings.Count(before, "\n"); n > 0 {
+			pos.Line += n
+			pos.Column = len(before) - strings.LastIndex(before, "\n")
+		} else {
+			pos.Column += idx
+		}
+		pos.Offset += idx
+
+		return pos
+	}
+
+	return fset.Position(fd.Doc.Pos())
+}
@@ -0,0 +1,205 @@
+package aops
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Aspect declares a middleware in Go, it is the builder of StmtParams. Like that:
+//
+//	trace := aops.NewAspect("@trace").
+//		Before(`log.Println("before")`).
+//		Defer(`defer log.Println("after")`).
+//		Import("log", "github.com/sirupsen/logrus")
+//	stmt, err := aops.Aspects(trace)
+//
+// Every method adds one StmtParam, the Source of it is the line which invokes the method,
+// so the error of snippet points to the Go source. Only the first StmtParam of a kind is woven,
+// so the repeated calls of a kind are merged into the first one, and Before and Inject are merged
+// as Inject. `Build` validates the aspect like the config file does, the repeated calls of a kind
+// which depend on the different variable, function or type can not be merged, they are an error.
+type Aspect struct {
+	id string
+	sp StmtParams
+}
+
+// NewAspect create an Aspect with the middleware id, e.g. `@trace`.
+func NewAspect(id string) *Aspect {
+	return &Aspect{id: id}
+}
+
+// ID returns the middleware id.
+func (a *Aspect) ID() string {
+	return a.id
+}
+
+// Order set the order of aspect, smaller is outer. More detail please reference `StmtParams`.
+func (a *Aspect) Order(order int) *Aspect {
+	a.sp.Order = order
+	return a
+}
+
+// Before add the expressions in the head of function body, e.g. `log.Println("before")` or `func(){...}()`.
+// Its kind is AddFuncWithoutDepends.
+func (a *Aspect) Before(src ...string) *Aspect {
+	return a.add(StmtParam{Kind: AddFuncWithoutDepends, Stmt: src})
+}
+
+// Inject is the same as Before, but declares the params of AOP id before the expressions, e.g. `@trace(name:@inject)`.
+// Its kind is AddFuncWithoutDependsWithInject.
+func (a *Aspect) Inject(src ...string) *Aspect {
+	return a.add(StmtParam{Kind: AddFuncWithoutDependsWithInject, Stmt: src})
+}
+
+// Defer add the defer stmts in the head of function body, e.g. `defer log.Println("after")`.
+// Its kind is AddDeferFuncStmt.
+func (a *Aspect) Defer(src ...string) *Aspect {
+	return a.add(StmtParam{Kind: AddDeferFuncStmt, Stmt: src})
+}
+
+// AfterVar add the stmts bellow the declaration of variable depend, e.g. `err := do()`.
+// Its kind is AddFuncWithVarStmt.
+func (a *Aspect) AfterVar(depend string, src ...string) *Aspect {
+	return a.add(StmtParam{Kind: AddFuncWithVarStmt, Stmt: src, Depends: []string{depend}})
+}
+
+// AfterFunc add the stmts bellow the invocation of function funcName, e.g. `math.Round`.
+// Its kind is AddFuncWithVarStmt.
+func (a *Aspect) AfterFunc(funcName string, src ...string) *Aspect {
+	return a.add(StmtParam{Kind: AddFuncWithVarStmt, Stmt: src, FuncDepends: []string{funcName}})
+}
+
+// AfterType add the stmts bellow the declaration of the first variable of type typ, e.g. `context.Context`,
+// `__varName__` in the stmts is replaced by the variable name. Its kind is AddFuncWithVarStmt.
+func (a *Aspect) AfterType(typ string, src ...string) *Aspect {
+	return a.add(StmtParam{Kind: AddFuncWithVarStmt, Stmt: src, DependType: typ})
+}
+
+// Return add the stmts in the head of the function which is returned.
+// Its kind is AddReturnFuncWithoutVarStmt.
+func (a *Aspect) Return(src ...string) *Aspect {
+	return a.add(StmtParam{Kind: AddReturnFuncWithoutVarStmt, Stmt: src})
+}
+
+// ReturnWithVar add the stmts in the function which is returned, and bind the variable depend.
+// Its kind is AddReturnFuncWithVarStmt.
+func (a *Aspect) ReturnWithVar(depend string, src ...string) *Aspect {
+	return a.add(StmtParam{Kind: AddReturnFuncWithVarStmt, Stmt: src, Depends: []string{depend}})
+}
+
+// Around add the stmts which replace the context of function, `__ctx__` is the variable of context,
+// e.g. `__ctx__, span := tracer.Start(__ctx__, "{{.FullName}}")`. Its kind is AddAroundCtxStmt.
+func (a *Aspect) Around(src ...string) *Aspect {
+	return a.add(StmtParam{Kind: AddAroundCtxStmt, Stmt: src})
+}
+
+// Param declare an argument which the id accepts, more detail please reference `Param`.
+func (a *Aspect) Param(p Param) *Aspect {
+	a.sp.Params = append(a.sp.Params, p)
+	return a
+}
+
+// Import add the package that the code imports. name can be empty, path can be quoted or not,
+// e.g. Import("log", "github.com/sirupsen/logrus").
+func (a *Aspect) Import(name, path string) *Aspect {
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, `"`) && !strings.HasPrefix(path, "`") {
+		path = strconv.Quote(path)
+	}
+
+	a.sp.Packs = append(a.sp.Packs, Pack{Name: strings.TrimSpace(name), Path: path})
+	return a
+}
+
+// add append sp with the Source of the caller of builder method. If a StmtParam of the same kind
+// with the same depends exists, the code of sp is appended to it and keeps its Source.
+func (a *Aspect) add(sp StmtParam) *Aspect {
+	if _, file, line, ok := runtime.Caller(2); ok {
+		sp.Source = Source{File: file, Line: line}
+	}
+
+	for i, s := range a.sp.Stmts {
+		if !sameKind(s, sp) {
+			continue
+		}
+		if sp.Kind == AddFuncWithoutDependsWithInject {
+			a.sp.Stmts[i].Kind = sp.Kind
+		}
+		a.sp.Stmts[i].Stmt = append(a.sp.Stmts[i].Stmt, sp.Stmt...)
+		return a
+	}
+
+	a.sp.Stmts = append(a.sp.Stmts, sp)
+	return a
+}
+
+// sameKind report whether sp can be merged into s. AddFuncWithoutDepends and AddFuncWithoutDependsWithInject
+// are the same kind, since only the first of them is woven.
+func sameKind(s, sp StmtParam) bool {
+	return kindGroup(s.Kind) == kindGroup(sp.Kind) &&
+		strings.Join(s.Depends, ",") == strings.Join(sp.Depends, ",") &&
+		strings.Join(s.FuncDepends, ",") == strings.Join(sp.FuncDepends, ",") &&
+		s.DependType == sp.DependType
+}
+
+// kindGroup return the kind which k is woven as.
+func kindGroup(k OperationKind) OperationKind {
+	if k == AddFuncWithoutDependsWithInject {
+		return AddFuncWithoutDepends
+	}
+
+	return k
+}
+
+// Build validate the aspect and return its StmtParams. It reports the invalid id, the invalid params,
+// the stmt without code and the code can not be parsed, the last one is a *SnippetError.
+func (a *Aspect) Build() (StmtParams, error) {
+	if len(a.id) < 2 || !strings.HasPrefix(a.id, "@") || strings.HasSuffix(a.id, "@") || strings.ContainsAny(a.id, " \t\n") {
+		return StmtParams{}, fmt.Errorf("invalid middleware id %q", a.id)
+	}
+
+	first := make(map[OperationKind]int)
+	for i, s := range a.sp.Stmts {
+		if len(s.Stmt) == 0 {
+			return StmtParams{}, fmt.Errorf("%s:%d: middleware %s: Stmt[%d] has no code", s.Source.File, s.Source.Line, a.id, i)
+		}
+		if j, exist := first[kindGroup(s.Kind)]; exist {
+			return StmtParams{}, fmt.Errorf("%s:%d: middleware %s: Stmt[%d] has the same kind as Stmt[%d] but different depends, only the first one is woven",
+				s.Source.File, s.Source.Line, a.id, i, j)
+		}
+		first[kindGroup(s.Kind)] = i
+	}
+
+	err := CheckParams(a.id, a.sp.Params)
+	if err != nil {
+		return StmtParams{}, err
+	}
+
+	err = CheckStmtParams(a.id, a.sp)
+	if err != nil {
+		return StmtParams{}, err
+	}
+
+	return a.sp, nil
+}
+
+// Aspects build all aspects, and return the StmtParams of every aspect, key is the middleware id.
+// The result can pass to `AddCode` directly. Duplicate ids are an error.
+func Aspects(as ...*Aspect) (map[string]StmtParams, error) {
+	stmt := make(map[string]StmtParams, len(as))
+	for _, a := range as {
+		if _, exist := stmt[a.id]; exist {
+			return nil, fmt.Errorf("duplicate middleware id %q", a.id)
+		}
+
+		sp, err := a.Build()
+		if err != nil {
+			return nil, err
+		}
+		stmt[a.id] = sp
+	}
+
+	return stmt, nil
+}
@@ -0,0 +1,130 @@
+package aops
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAspect(t *testing.T) {
+	a := NewAspect("@trace").
+		Order(-1).
+		Before(`log.Println("before")`).
+		Defer(`defer log.Println("after")`).
+		AfterVar("err", `log.Println(err)`).
+		Import("log", "github.com/sirupsen/logrus")
+
+	got, err := a.Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	for i, s := range got.Stmts {
+		if !strings.HasSuffix(s.Source.File, "aspect_test.go") || s.Source.Line == 0 {
+			t.Errorf("Build() Stmts[%d].Source = %v, want the line in aspect_test.go", i, s.Source)
+		}
+		got.Stmts[i].Source = Source{}
+	}
+
+	want := StmtParams{
+		Stmts: []StmtParam{
+			{Kind: AddFuncWithoutDepends, Stmt: []string{`log.Println("before")`}},
+			{Kind: AddDeferFuncStmt, Stmt: []string{`defer log.Println("after")`}},
+			{Kind: AddFuncWithVarStmt, Stmt: []string{`log.Println(err)`}, Depends: []string{"err"}},
+		},
+		Packs: []Pack{{Name: "log", Path: `"github.com/sirupsen/logrus"`}},
+		Order: -1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Build() got = %+v, want %+v", got, want)
+	}
+}
+
+func TestAspectMerge(t *testing.T) {
+	got, err := NewAspect("@trace").
+		Before(`log.Println("a")`).
+		Defer(`defer log.Println("c")`).
+		Inject(`log.Println("b")`).
+		Defer(`defer log.Println("d")`).
+		AfterVar("err", `log.Println(err)`).
+		AfterVar("err", `log.Println("e")`).
+		Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	for i := range got.Stmts {
+		got.Stmts[i].Source = Source{}
+	}
+	want := []StmtParam{
+		{Kind: AddFuncWithoutDependsWithInject, Stmt: []string{`log.Println("a")`, `log.Println("b")`}},
+		{Kind: AddDeferFuncStmt, Stmt: []string{`defer log.Println("c")`, `defer log.Println("d")`}},
+		{Kind: AddFuncWithVarStmt, Stmt: []string{`log.Println(err)`, `log.Println("e")`}, Depends: []string{"err"}},
+	}
+	if !reflect.DeepEqual(got.Stmts, want) {
+		t.Errorf("Build() Stmts = %+v, want %+v", got.Stmts, want)
+	}
+}
+
+func TestAspectError(t *testing.T) {
+	tests := []struct {
+		name    string
+		aspects []*Aspect
+		wantErr string
+	}{
+		{
+			name:    "invalid id",
+			aspects: []*Aspect{NewAspect("trace")},
+			wantErr: `invalid middleware id "trace"`,
+		},
+		{
+			name:    "no code",
+			aspects: []*Aspect{NewAspect("@trace").Before()},
+			wantErr: "middleware @trace: Stmt[0] has no code",
+		},
+		{
+			name:    "invalid code",
+			aspects: []*Aspect{NewAspect("@trace").Defer(`defer log.Println(`)},
+			wantErr: "middleware @trace: Stmt[0] code[0]: 1:19: expected operand",
+		},
+		{
+			name:    "same kind with different depends",
+			aspects: []*Aspect{NewAspect("@trace").AfterVar("err", `log.Println(err)`).AfterFunc("do", `log.Println()`)},
+			wantErr: "middleware @trace: Stmt[1] has the same kind as Stmt[0] but different depends, only the first one is woven",
+		},
+		{
+			name:    "duplicate id",
+			aspects: []*Aspect{NewAspect("@trace"), NewAspect("@trace")},
+			wantErr: `duplicate middleware id "@trace"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Aspects(tt.aspects...)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Aspects() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAspectWeave(t *testing.T) {
+	aspect := func(id string, order int) *Aspect {
+		return NewAspect(id).
+			Order(order).
+			Before(`fmt.Println("` + id + ` before")`).
+			Defer(`defer fmt.Println("` + id + ` after")`)
+	}
+
+	stmt, err := Aspects(
+		aspect("@middleware-log", 0),
+		aspect("@middleware-trace", 0),
+		aspect("@middleware-recover", -1),
+	)
+	if err != nil {
+		t.Fatalf("Aspects() error = %v", err)
+	}
+
+	// the same aspects as TestMiddlewareOrder
+	weaveGolden(t, "../cases/middleware-order/code.go", "../cases/middleware-order/code.golden", stmt)
+}
@@ -0,0 +1,332 @@
+package aops
+
+import (
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// stmtOrigin is the code which inserts a stmt: the Stmt of middleware id, or the DeclStmt if decl is true.
+// stmt is -1 for the declarations of arguments. source is where the Stmt is declared.
+type stmtOrigin struct {
+	id     string
+	stmt   int
+	decl   bool
+	source Source
+}
+
+// kindIndex get the index of the first stmt of kinds in sp, or -1 if sp has no such stmt.
+func kindIndex(sp StmtParams, kinds ...OperationKind) int {
+	for i, s := range sp.Stmts {
+		for _, k := range kinds {
+			if s.Kind == k {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// bodyStmts get all the stmts in the body of fd, includes the nested ones.
+func bodyStmts(fd *ast.FuncDecl) (stmts []ast.Stmt) {
+	ast.Inspect(fd.Body, func(n ast.Node) bool {
+		if s, ok := n.(ast.Stmt); ok {
+			stmts = append(stmts, s)
+		}
+		return true
+	})
+
+	return
+}
+
+// recordOrigins run op, then save o as the origin of the stmts which op inserts in fd.
+// The origin of stmt is saved once, since the stmt may be moved by the later operators.
+func recordOrigins(fd *ast.FuncDecl, origins map[ast.Stmt]stmtOrigin, o stmtOrigin, op func() error) error {
+	if origins == nil {
+		return op()
+	}
+
+	before := make(map[ast.Stmt]bool)
+	for _, s := range bodyStmts(fd) {
+		before[s] = true
+	}
+
+	if err := op(); err != nil {
+		return err
+	}
+
+	for _, s := range bodyStmts(fd) {
+		if _, exist := origins[s]; !exist && !before[s] {
+			origins[s] = o
+		}
+	}
+
+	return nil
+}
+
+// originRange is the range of an inserted stmt in the woven source.
+type originRange struct {
+	pos, end token.Pos
+	origin   stmtOrigin
+}
+
+// typeCheck type-check the packages which files belong to, the imports should be added to the files already.
+// The files with errors are marked failed. Every error in woven files is attributed to the stmt which inserts
+// the code, the error out of inserted code has no ID.
+func typeCheck(files []*wovenFile) (TypeErrors, error) {
+	dirs := make(map[string][]*wovenFile)
+	for _, wf := range files {
+		dir := filepath.Dir(wf.name)
+		dirs[dir] = append(dirs[dir], wf)
+	}
+
+	names := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		names = append(names, dir)
+	}
+	sort.Strings(names)
+
+	var errs TypeErrors
+	for _, dir := range names {
+		_errs, err := checkPackage(dir, dirs[dir])
+		if err != nil {
+			return nil, err
+		}
+		errs = append(errs, _errs...)
+	}
+
+	return errs, nil
+}
+
+// checkPackage type-check the package in dir, the woven files replace the files of the same name.
+// The positions of errors are mapped back by `mapPosition`, since the woven files are not written.
+func checkPackage(dir string, woven []*wovenFile) (TypeErrors, error) {
+	fset := token.NewFileSet()
+	byName := make(map[string]*wovenFile, len(woven))
+	mappers := make(map[string]*posMapper, len(woven))
+	var astFiles []*ast.File
+	for _, wf := range woven {
+		f, err := parser.ParseFile(fset, wf.name, wf.src, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		byName[filepath.Base(wf.name)] = wf
+		mappers[wf.name] = newPosMapper(fset, wf, f)
+		astFiles = append(astFiles, f)
+	}
+
+	pkgName := astFiles[0].Name.Name
+	others, err := packageFiles(fset, dir, pkgName, func(name string) bool { return byName[name] != nil })
+	if err != nil {
+		return nil, err
+	}
+	astFiles = append(astFiles, others...)
+
+	var errs TypeErrors
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		Error: func(err error) {
+			te, ok := err.(types.Error)
+			if !ok {
+				return
+			}
+			m, exist := mappers[fset.Position(te.Pos).Filename]
+			if !exist {
+				return
+			}
+
+			m.wf.failed = true
+			e := &TypeError{Pos: m.mapPosition(te.Pos), Msg: te.Msg}
+			if r := innermostRange(m.ranges, te.Pos); r != nil {
+				e.ID, e.Stmt, e.Decl, e.Source = r.origin.id, r.origin.stmt, r.origin.decl, r.origin.source
+			}
+			errs = append(errs, e)
+		},
+	}
+
+	conf.Check(packagePath(woven[0].name, pkgName), fset, astFiles, nil)
+
+	return errs, nil
+}
+
+// packageFiles parse the files of package pkgName in dir for the build context, the test files and the files
+// which skip reports are excluded.
+func packageFiles(fset *token.FileSet, dir, pkgName string, skip func(name string) bool) ([]*ast.File, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*ast.File
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") || skip(name) {
+			continue
+		}
+		if ok, err := build.Default.MatchFile(dir, name); err != nil || !ok {
+			continue
+		}
+
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		if f.Name.Name == pkgName {
+			files = append(files, f)
+		}
+	}
+
+	return files, nil
+}
+
+// packagePath get the import path of the package which file belongs to, or pkgName if it is unknown.
+func packagePath(file, pkgName string) string {
+	if path := getImportPath(file); path != "" {
+		return path
+	}
+
+	return pkgName
+}
+
+// posMapper maps the positions of the woven source of wf, which is parsed as f in fset, back to the positions
+// that the user can see. ranges are the inserted stmts, nodes are the original stmts and decls matched to the
+// ones of wf.f.
+type posMapper struct {
+	fset   *token.FileSet
+	wf     *wovenFile
+	ranges []originRange
+	nodes  [][2]ast.Node
+}
+
+func newPosMapper(fset *token.FileSet, wf *wovenFile, f *ast.File) *posMapper {
+	m := &posMapper{fset: fset, wf: wf, ranges: originRanges(wf, f)}
+
+	// the same as `addLineDirectives`, the nodes are matched by order and the imports are skipped.
+	var woven, parsed []ast.Node
+	collect := func(file *ast.File, nodes *[]ast.Node) {
+		ast.Inspect(file, func(n ast.Node) bool {
+			switch t := n.(type) {
+			case *ast.GenDecl:
+				if t.Tok == token.IMPORT {
+					return false
+				}
+				*nodes = append(*nodes, n)
+			case ast.Stmt, ast.Decl:
+				*nodes = append(*nodes, n)
+			}
+			return true
+		})
+	}
+	collect(wf.f, &woven)
+	collect(f, &parsed)
+	if len(woven) != len(parsed) {
+		return m
+	}
+
+	var injectedEnd token.Pos
+	for i, n := range woven {
+		p := parsed[i]
+		if p.Pos() < injectedEnd {
+			continue
+		}
+		if s, ok := n.(ast.Stmt); ok {
+			if _, exist := wf.origins[s]; exist {
+				injectedEnd = p.End()
+				continue
+			}
+		}
+		m.nodes = append(m.nodes, [2]ast.Node{n, p})
+	}
+
+	return m
+}
+
+// mapPosition map pos of the woven source. The position in inserted code is mapped to the snippet, the file and
+// line are the Source of Stmt like `//line` directives, e.g. `aop.toml:7:19`, the line is added by the line in the
+// inserted stmt, and the column is relative to its indent. The position in original code is mapped to the file
+// before weaving. The position in the added imports has no line.
+func (m *posMapper) mapPosition(pos token.Pos) token.Position {
+	p := m.fset.Position(pos)
+	if r := innermostRange(m.ranges, pos); r != nil {
+		start := m.fset.Position(r.pos)
+		file, line := originSource(r.origin)
+		column := p.Column - start.Column + 1
+		if column < 1 {
+			column = 1
+		}
+		return token.Position{Filename: file, Line: line + p.Line - start.Line, Column: column}
+	}
+
+	// the innermost original node which contains pos, the code between its start and pos is not changed.
+	var found [2]ast.Node
+	for _, n := range m.nodes {
+		if pos < n[1].Pos() || pos >= n[1].End() {
+			continue
+		}
+		if found[1] == nil || n[1].End()-n[1].Pos() < found[1].End()-found[1].Pos() {
+			found = n
+		}
+	}
+	if found[0] == nil {
+		return token.Position{Filename: m.wf.name}
+	}
+
+	file := m.wf.fset.File(found[0].Pos())
+	offset := file.Offset(found[0].Pos()) + p.Offset - m.fset.Position(found[1].Pos()).Offset
+	if offset < 0 || offset > file.Size() {
+		return token.Position{Filename: m.wf.name}
+	}
+
+	return m.wf.fset.Position(file.Pos(offset))
+}
+
+// originRanges get the ranges of inserted stmts in f, which is parsed from the source of wf.
+// The inserted code is printed from wf.f, so the stmts of f and wf.f are matched by order.
+func originRanges(wf *wovenFile, f *ast.File) (ranges []originRange) {
+	var woven, parsed []ast.Stmt
+	collect := func(file *ast.File, stmts *[]ast.Stmt) {
+		ast.Inspect(file, func(n ast.Node) bool {
+			if s, ok := n.(ast.Stmt); ok {
+				*stmts = append(*stmts, s)
+			}
+			return true
+		})
+	}
+	collect(wf.f, &woven)
+	collect(f, &parsed)
+	if len(woven) != len(parsed) {
+		return nil
+	}
+
+	for i, s := range woven {
+		if o, exist := wf.origins[s]; exist {
+			ranges = append(ranges, originRange{pos: parsed[i].Pos(), end: parsed[i].End(), origin: o})
+		}
+	}
+
+	return
+}
+
+// innermostRange get the smallest range which contains pos, or nil if no range contains it.
+func innermostRange(ranges []originRange, pos token.Pos) *originRange {
+	var result *originRange
+	for i := range ranges {
+		r := &ranges[i]
+		if pos < r.pos || pos >= r.end {
+			continue
+		}
+		if result == nil || r.end-r.pos < result.end-result.pos {
+			result = r
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,131 @@
+package aops
+
+import (
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAddCodeTypeCheck(t *testing.T) {
+	src := `package orders
+
+import "fmt"
+
+// Create
+// @trace
+func Create(id int) error {
+	fmt.Println(id)
+	return nil
+}
+`
+	tests := []struct {
+		name    string
+		src     string
+		stmt    StmtParams
+		wantErr []TypeError
+		want    string
+	}{
+		{
+			name: "valid",
+			stmt: StmtParams{
+				Stmts: []StmtParam{
+					{Kind: AddDeferFuncStmt, Stmt: []string{`defer log.Println("done")`}},
+				},
+				Packs: []Pack{{Path: `"log"`}},
+			},
+			want: `Println("done")`,
+		},
+		{
+			name: "invalid",
+			stmt: StmtParams{
+				Stmts: []StmtParam{
+					{Kind: AddFuncWithoutDepends, Stmt: []string{`fmt.Println(id)`}},
+					{Kind: AddDeferFuncStmt, Stmt: []string{`defer log.Println(span)`}, Source: Source{File: "aop.toml", Line: 7}},
+				},
+				Packs: []Pack{{Path: `"log"`}},
+			},
+			wantErr: []TypeError{
+				{ID: "@trace", Stmt: 1, Source: Source{File: "aop.toml", Line: 7}, Pos: token.Position{Filename: "aop.toml", Line: 7, Column: 19}, Msg: "undefined: span"},
+			},
+		},
+		{
+			name: "arguments",
+			stmt: StmtParams{
+				Stmts: []StmtParam{
+					{Kind: AddFuncWithoutDependsWithInject, Stmt: []string{`fmt.Println(id)`}},
+				},
+				Params: []Param{{Name: "limit", Type: "string", Default: "1 + 2"}},
+			},
+			wantErr: []TypeError{
+				{ID: "@trace", Stmt: -1, Pos: token.Position{Filename: "@trace/arguments", Line: 1, Column: 20}, Msg: "cannot use 1 + 2 (untyped int constant 3) as string value in variable declaration"},
+			},
+		},
+		{
+			name: "original code",
+			src:  strings.Replace(src, "return nil", "return id", 1),
+			stmt: StmtParams{
+				Stmts: []StmtParam{
+					{Kind: AddDeferFuncStmt, Stmt: []string{`defer log.Println("done")`}},
+				},
+				Packs: []Pack{{Path: `"log"`}},
+			},
+			wantErr: []TypeError{
+				{Pos: token.Position{Filename: "code.go", Line: 9, Column: 9}, Msg: "cannot use id (variable of type int) as error value in return statement: int does not implement error (missing method Error)"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.src == "" {
+				tt.src = src
+			}
+			file := filepath.Join(t.TempDir(), "code.go")
+			if err := os.WriteFile(file, []byte(tt.src), 0666); err != nil {
+				t.Fatal(err)
+			}
+			pkg, err := ParseDir(filepath.Dir(file), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			
+			stmt := map[string]StmtParams{"@trace": tt.stmt}
+			modify, err := AddCodeWithOptions(Position(pkg, map[string]struct{}{"@trace": {}}), stmt, true, Options{TypeCheck: true})
+			got, _ := os.ReadFile(file)
+			
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("AddCodeWithOptions() error = %v", err)
+				}
+				if !reflect.DeepEqual(modify, map[string][]string{file: {"@trace"}}) {
+					t.Errorf("AddCodeWithOptions() modify = %v", modify)
+				}
+				if !strings.Contains(string(got), tt.want) || !strings.Contains(string(got), `"log"`) {
+					t.Errorf("AddCodeWithOptions() wrote:\n%s", got)
+				}
+				return
+			}
+			
+			errs, ok := err.(TypeErrors)
+			if !ok {
+				t.Fatalf("AddCodeWithOptions() error = %v, want TypeErrors", err)
+			}
+			var gotErr []TypeError
+			for _, e := range errs {
+				pos := token.Position{Filename: e.Pos.Filename, Line: e.Pos.Line, Column: e.Pos.Column}
+				if pos.Filename == file {
+					pos.Filename = "code.go"
+				}
+				gotErr = append(gotErr, TypeError{ID: e.ID, Stmt: e.Stmt, Decl: e.Decl, Source: e.Source, Pos: pos, Msg: e.Msg})
+			}
+			if !reflect.DeepEqual(gotErr, tt.wantErr) {
+				t.Errorf("AddCodeWithOptions() error = %v, want %v", gotErr, tt.wantErr)
+			}
+			if len(modify) != 0 || string(got) != tt.src {
+				t.Errorf("AddCodeWithOptions() should not write the file, modify = %v, got:\n%s", modify, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,333 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ValidateSchema validate file and all the files it includes against the schema generated by `Schema`.
+// Every mistake is reported with the line of the middleware or Stmt table which contains it, and the
+// path of value in the document, like `/middleware/0/Stmt/1/kind`. Return nil if all the files are valid,
+// otherwise return ErrorList. The code is not parsed, `Parse` does that.
+func ValidateSchema(file string) error {
+	data, err := Schema()
+	if err != nil {
+		return err
+	}
+	var schema interface{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return err
+	}
+
+	var errs ErrorList
+	if err := validateSchema(file, schema, make(map[string]bool), &errs); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// validateSchema validate file and its includes, the files in visited are skipped, so an include cycle ends.
+func validateSchema(file string, schema interface{}, visited map[string]bool, errs *ErrorList) error {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	if visited[abs] {
+		return nil
+	}
+	visited[abs] = true
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	doc, err := decodeDocument(file, data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	midLines, stmtLines, err := getFormat(file).lines(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+
+	for _, e := range schemaErrors(schema, doc) {
+		*errs = append(*errs, Error{
+			File: file,
+			Line: pathLine(e.path, midLines, stmtLines),
+			Msg:  fmt.Sprintf("schema: %s: %s", e.pathString(), e.msg),
+		})
+	}
+
+	var includes []string
+	if m, ok := doc.(map[string]interface{}); ok {
+		if list, ok := m["include"].([]interface{}); ok {
+			for _, i := range list {
+				if s, ok := i.(string); ok {
+					includes = append(includes, s)
+				}
+			}
+		}
+	}
+	files, err := resolveInclude(file, includes)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := validateSchema(f, schema, visited, errs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeDocument decode data without the Go types, so the unknown keys and wrong types are kept
+// for the schema. The result is converted to the values of `encoding/json`, whatever the format is.
+func decodeDocument(file string, data []byte) (doc interface{}, err error) {
+	var raw interface{}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".json":
+		err = json.Unmarshal(data, &raw)
+	default:
+		var m map[string]interface{}
+		_, err = toml.Decode(string(data), &m)
+		raw = m
+	}
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		raw = map[string]interface{}{}
+	}
+
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &doc)
+	return
+}
+
+// pathLine get the line of the innermost middleware or Stmt table which contains the value of path.
+func pathLine(path []string, midLines []int, stmtLines [][]int) int {
+	if len(path) < 2 || path[0] != "middleware" {
+		return 0
+	}
+	i, err := strconv.Atoi(path[1])
+	if err != nil || i >= len(midLines) {
+		return 0
+	}
+	if len(path) >= 4 && path[2] == "Stmt" {
+		if j, err := strconv.Atoi(path[3]); err == nil && j < len(stmtLines[i]) {
+			return stmtLines[i][j]
+		}
+	}
+
+	return midLines[i]
+}
+
+// schemaError is a value which does not match the schema, path is the keys and indexes from the root.
+type schemaError struct {
+	path []string
+	msg  string
+}
+
+func (e schemaError) pathString() string {
+	return "/" + strings.Join(e.path, "/")
+}
+
+// schemaErrors validate v against schema s. Only the keywords generated by `Schema` are supported:
+// type, properties, additionalProperties, required, items, enum, const, allOf, anyOf, oneOf, if, then
+// and dependencies, and the boolean schema.
+func schemaErrors(s interface{}, v interface{}, path ...string) (errs []schemaError) {
+	report := func(format string, a ...interface{}) {
+		errs = append(errs, schemaError{path: path, msg: fmt.Sprintf(format, a...)})
+	}
+
+	if b, ok := s.(bool); ok {
+		if !b {
+			report("is not allowed")
+		}
+		return
+	}
+	schema, ok := s.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	if t, ok := schema["type"].(string); ok && !isType(v, t) {
+		report("should be %s, got %s", t, typeName(v))
+		return
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok && !contains(enum, v) {
+		var values []string
+		for _, e := range enum {
+			values = append(values, fmt.Sprint(e))
+		}
+		report("%v is not one of %s", v, strings.Join(values, ", "))
+	}
+	if c, ok := schema["const"]; ok && !reflect.DeepEqual(c, v) {
+		report("should be %v", c)
+	}
+
+	if obj, ok := v.(map[string]interface{}); ok {
+		props, _ := schema["properties"].(map[string]interface{})
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if p, exist := props[k]; exist {
+				if p == false {
+					errs = append(errs, schemaError{path: append(path[:len(path):len(path)], k), msg: "is not allowed"})
+					continue
+				}
+				errs = append(errs, schemaErrors(p, obj[k], append(path[:len(path):len(path)], k)...)...)
+			} else if schema["additionalProperties"] == false {
+				errs = append(errs, schemaError{path: append(path[:len(path):len(path)], k), msg: "is an unknown key"})
+			}
+		}
+		for _, r := range strings2(schema["required"]) {
+			if _, exist := obj[r]; !exist {
+				report("%s is required", r)
+			}
+		}
+		if deps, ok := schema["dependencies"].(map[string]interface{}); ok {
+			for _, k := range keys {
+				for _, d := range strings2(deps[k]) {
+					if _, exist := obj[d]; !exist {
+						report("%s requires %s", k, d)
+					}
+				}
+			}
+		}
+	}
+
+	if arr, ok := v.([]interface{}); ok {
+		if items, ok := schema["items"]; ok {
+			for i, item := range arr {
+				errs = append(errs, schemaErrors(items, item, append(path[:len(path):len(path)], strconv.Itoa(i))...)...)
+			}
+		}
+	}
+
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			errs = append(errs, schemaErrors(sub, v, path...)...)
+		}
+	}
+	if cond, ok := schema["if"]; ok && len(schemaErrors(cond, v, path...)) == 0 {
+		if then, ok := schema["then"]; ok {
+			errs = append(errs, schemaErrors(then, v, path...)...)
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok && matched(anyOf, v) == 0 {
+		report("needs one of %s", describe(anyOf))
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok && matched(oneOf, v) != 1 {
+		report("needs exactly one of %s", describe(oneOf))
+	}
+
+	return
+}
+
+// matched count the schemas which v matches.
+func matched(schemas []interface{}, v interface{}) (n int) {
+	for _, s := range schemas {
+		if len(schemaErrors(s, v)) == 0 {
+			n++
+		}
+	}
+
+	return
+}
+
+// describe the alternatives of anyOf or oneOf, they are the required keys in the schema of `Schema`.
+func describe(schemas []interface{}) string {
+	var keys []string
+	for _, s := range schemas {
+		m, _ := s.(map[string]interface{})
+		keys = append(keys, strings.Join(strings2(m["required"]), " and "))
+	}
+
+	return strings.Join(keys, ", ")
+}
+
+func isType(v interface{}, t string) bool {
+	switch t {
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	}
+
+	return true
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case nil:
+		return "null"
+	}
+
+	return fmt.Sprintf("%T", v)
+}
+
+func contains(list []interface{}, v interface{}) bool {
+	for _, l := range list {
+		if reflect.DeepEqual(l, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// strings2 convert the JSON array of strings to []string.
+func strings2(v interface{}) (result []string) {
+	list, _ := v.([]interface{})
+	for _, l := range list {
+		if s, ok := l.(string); ok {
+			result = append(result, s)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,276 @@
+// Package config loads the aspect config file, e.g. aop.toml, aop.yaml or aop.json, and converts it to
+// the StmtParams that `aops.AddCode` uses. Both the goAOP CLI and programmatic callers
+// can use it:
+//
+//	stmt, err := config.Load("aop.toml")
+//	if err != nil {
+//		return err
+//	}
+//	modify, err := aops.AddCode(aops.Position(pkgs, config.Ids(stmt)), stmt, true)
+package config
+
+import (
+	"fmt"
+	"github.com/runways/goAOP/aops"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Config is the runtime config. Include saves the paths of other config files,
+// more detail please reference `Parse`.
+type Config struct {
+	Include    []string                   `toml:"include" yaml:"include" json:"include"`
+	MidWare    []Middleware               `toml:"middleware" yaml:"middleware" json:"middleware"`
+	MidWareMap map[string]aops.StmtParams `toml:"-" yaml:"-" json:"-"`
+}
+
+// Middleware declares an aspect. Order decides the nesting when a function
+// has several aspects, smaller is outer. The default order is 0.
+// Param declares the arguments which the id accepts, more detail please reference `aops.Param`.
+type Middleware struct {
+	ID      string  `toml:"id" yaml:"id" json:"id" schema:"required"`
+	Order   int     `toml:"order,omitempty" yaml:"order,omitempty" json:"order,omitempty"`
+	Stmt    []Stmt  `toml:"Stmt" yaml:"Stmt" json:"Stmt"`
+	Package []Pack  `toml:"package" yaml:"package" json:"package"`
+	Param   []Param `toml:"param,omitempty" yaml:"param,omitempty" json:"param,omitempty"`
+}
+
+// Param is an argument of id, like `qps` in `@ratelimit(qps:100)`. Type and Default are Go source.
+type Param struct {
+	Name     string `toml:"name" yaml:"name" json:"name" schema:"required"`
+	Type     string `toml:"type,omitempty" yaml:"type,omitempty" json:"type,omitempty"`
+	Default  string `toml:"default,omitempty" yaml:"default,omitempty" json:"default,omitempty"`
+	Required bool   `toml:"required,omitempty" yaml:"required,omitempty" json:"required,omitempty"`
+}
+
+// Pack is the package that the aspect code imports.
+type Pack struct {
+	Name string `toml:"name" yaml:"name" json:"name"`
+	Path string `toml:"path" yaml:"path" json:"path" schema:"required"`
+}
+
+// Stmt save all the code will injection to source code
+// ID is the middleware id, should match with comment in function.
+// Kind is the middleware type.Valid values declare in the `aops/const.go`.
+// Code is a string array, save the code will injection to source code.
+// File and Func load the code from a function in a .go file instead of Code,
+// more detail please reference `loadFuncs`.
+// Depend is a string array, save the injection conditions. Now only support
+// signal variable. No need type variable type.
+// Type binds the variable by type instead of Depend, e.g. `context.Context`, the code gets
+// the variable name by `__varName__`.
+type Stmt struct {
+	Kind      string   `toml:"kind" yaml:"kind" json:"kind" schema:"required"`
+	Code      []string `toml:"code,omitempty" yaml:"code,omitempty" json:"code,omitempty"`
+	File      string   `toml:"file,omitempty" yaml:"file,omitempty" json:"file,omitempty"`
+	Func      string   `toml:"func,omitempty" yaml:"func,omitempty" json:"func,omitempty"`
+	Depend    []string `toml:"depend,omitempty" yaml:"depend,omitempty" json:"depend,omitempty"`
+	FunDepend []string `toml:"funDepend,omitempty" yaml:"funDepend,omitempty" json:"funDepend,omitempty"`
+	Type      string   `toml:"type,omitempty" yaml:"type,omitempty" json:"type,omitempty"`
+}
+
+// Load parse file and all the files it includes, then return the StmtParams of every
+// middleware, key is the middleware id. If the config is invalid, the error is an ErrorList.
+func Load(file string) (map[string]aops.StmtParams, error) {
+	c, err := Parse(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.MidWareMap, nil
+}
+
+// Ids get all middleware ids from stmt, the result can pass to `aops.Position` directly.
+func Ids(stmt map[string]aops.StmtParams) map[string]struct{} {
+	ids := make(map[string]struct{}, len(stmt))
+	for id := range stmt {
+		ids[id] = struct{}{}
+	}
+
+	return ids
+}
+
+// Parse parse file and all the files it includes.
+//
+// The include paths are relative to the file which includes them, and glob patterns like
+// `aspects/*.toml` are supported. Included files can include other files, but an include
+// cycle is an error.
+//
+// When several files declare the same middleware id, the including file wins over the files
+// it includes, and among the includes the later one wins. c.MidWare only saves the middlewares
+// declared in file, c.MidWareMap saves the merged result.
+func Parse(file string) (c Config, err error) {
+	return parseWithInclude(file, nil)
+}
+
+// parseWithInclude stack saves the absolute paths of files that are including,
+// use it find include cycle.
+func parseWithInclude(file string, stack []string) (c Config, err error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return
+	}
+
+	for _, s := range stack {
+		if s == abs {
+			return c, fmt.Errorf("include cycle: %s", strings.Join(append(stack, abs), " -> "))
+		}
+	}
+	stack = append(stack, abs)
+
+	c, err = ParseFile(file)
+	if err != nil {
+		return
+	}
+
+	includes, err := resolveInclude(file, c.Include)
+	if err != nil {
+		return
+	}
+
+	mwm := make(map[string]aops.StmtParams)
+	for _, i := range includes {
+		ic, err := parseWithInclude(i, stack)
+		if err != nil {
+			return c, err
+		}
+		for key, val := range ic.MidWareMap {
+			mwm[key] = val
+		}
+	}
+
+	for key, val := range c.MidWareMap {
+		mwm[key] = val
+	}
+
+	c.MidWareMap = mwm
+	return
+}
+
+// ParseFile parse and validate one config file, the includes are not followed.
+// The file format is chosen by extension: `.yaml` and `.yml` are YAML, `.json` is JSON,
+// others are TOML. All formats share the same schema. The code declared by `file` and `func`
+// is loaded by `loadFuncs` before validation.
+func ParseFile(file string) (c Config, err error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return
+	}
+
+	f := getFormat(file)
+	err = f.decode(data, &c)
+	if err != nil {
+		return c, fmt.Errorf("%s: %w", file, err)
+	}
+
+	midLines, stmtLines, err := f.lines(data)
+	if err != nil {
+		return c, fmt.Errorf("%s: %w", file, err)
+	}
+
+	errs := loadFuncs(file, &c, stmtLines)
+	if err := validate(file, c, midLines, stmtLines); err != nil {
+		errs = append(errs, err.(ErrorList)...)
+	}
+	if len(errs) > 0 {
+		return c, errs
+	}
+
+	mwm := make(map[string]aops.StmtParams, len(c.MidWare))
+	for i, m := range c.MidWare {
+		var lines []int
+		if i < len(stmtLines) {
+			lines = stmtLines[i]
+		}
+		mwm[m.ID] = convert(file, lines, m)
+	}
+
+	c.MidWareMap = mwm
+	return
+}
+
+// convert m to StmtParams. m should be validated, the unknown kinds are skipped.
+// file and lines are used to set the Source of every stmt, lines[i] is the line of m.Stmt[i].
+func convert(file string, lines []int, m Middleware) aops.StmtParams {
+	var p []aops.Pack
+	var stmtBlock []aops.StmtParam
+	for _, _p := range m.Package {
+		p = append(p, aops.Pack{
+			Name: strings.TrimSpace(_p.Name),
+			Path: strings.TrimSpace(_p.Path),
+		})
+	}
+
+	for i, s := range m.Stmt {
+		spec, exist := stmtKinds[strings.TrimSpace(strings.ToLower(s.Kind))]
+		if !exist {
+			continue
+		}
+
+		var depends []string
+		var dependType string
+		if spec.depend {
+			depends = s.Depend
+			dependType = strings.TrimSpace(s.Type)
+		}
+
+		stmtBlock = append(stmtBlock, aops.StmtParam{
+			Kind:        spec.kind,
+			Stmt:        s.Code,
+			Depends:     depends,
+			FuncDepends: s.FunDepend,
+			DependType:  dependType,
+			Source:      aops.Source{File: file},
+		})
+		if i < len(lines) {
+			stmtBlock[len(stmtBlock)-1].Source.Line = lines[i]
+		}
+	}
+
+	return aops.StmtParams{
+		Stmts:  stmtBlock,
+		Packs:  p,
+		Order:  m.Order,
+		Params: convertParams(m.Param),
+	}
+}
+
+// convertParams convert the params declared in config to aops.Param.
+func convertParams(params []Param) (result []aops.Param) {
+	for _, p := range params {
+		result = append(result, aops.Param{
+			Name:     strings.TrimSpace(p.Name),
+			Type:     strings.TrimSpace(p.Type),
+			Default:  strings.TrimSpace(p.Default),
+			Required: p.Required,
+		})
+	}
+
+	return
+}
+
+// resolveInclude convert include paths to file paths. The relative path is joined with the dir of file,
+// and the glob pattern is expanded by name order. A pattern which matches nothing is an error.
+func resolveInclude(file string, include []string) (files []string, err error) {
+	dir := filepath.Dir(file)
+	for _, i := range include {
+		i = strings.TrimSpace(i)
+		if !filepath.IsAbs(i) {
+			i = filepath.Join(dir, i)
+		}
+
+		matches, err := filepath.Glob(i)
+		if err != nil {
+			return nil, fmt.Errorf("%s: bad include pattern %q: %w", file, i, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%s: include %q matches no file", file, i)
+		}
+
+		// filepath.Glob returns matches by name order.
+		files = append(files, matches...)
+	}
+
+	return
+}
@@ -0,0 +1,830 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"github.com/runways/goAOP/aops"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+
+	subFile, _ := os.CreateTemp("", "")
+	os.WriteFile(subFile.Name(), []byte(`
+[[middleware]]
+    id="@middleware-b"
+	[[middleware.Stmt]]
+	kind="add-return-func-with-var"
+    code=["""func(){
+                log.Println("before")
+            }()"""]
+	depend=["err"]
+	[[middleware.Stmt]]
+	kind="add-return-func-without-var"
+    code =["""func(){
+                log.Println("before")
+            }()"""]
+`), 0777)
+
+	conf := fmt.Sprintf(`
+include=["%s"]
+[[middleware]]
+    id="@middleware-a"
+	[[middleware.Stmt]]
+	kind="add-func-without-depends"
+    code=["""func(){
+                log.Println("before")
+            }()"""]
+	[[middleware.Stmt]]
+	kind="add-func-with-var-depend"
+    code =["""func(){
+                log.Println("before")
+            }()"""]
+	depend=["str"]
+    [[middleware.package]]
+        name = "log"
+        path = """"github.com/sirupsen/logrus""""
+
+`, subFile.Name())
+	f, _ := os.CreateTemp("", "")
+	os.WriteFile(f.Name(), []byte(conf), 0777)
+
+	type args struct {
+		file string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantC   Config
+		wantErr bool
+	}{
+		{
+			name: "Normal parse",
+			args: struct{ file string }{file: f.Name()},
+			wantC: Config{
+				Include: []string{subFile.Name()},
+				MidWare: []Middleware{
+					{
+						ID: "@middleware-a",
+						Package: []Pack{
+							{Name: "log", Path: "\"github.com/sirupsen/logrus\""},
+						},
+						Stmt: []Stmt{
+							{
+								Kind: "add-func-without-depends",
+								Code: []string{`func(){
+                log.Println("before")
+            }()`},
+							},
+							{
+								Kind: "add-func-with-var-depend",
+								Code: []string{`func(){
+                log.Println("before")
+            }()`},
+								Depend: []string{"str"},
+							},
+						},
+					},
+				},
+				MidWareMap: map[string]aops.StmtParams{
+					"@middleware-b": aops.StmtParams{
+						DeclStmt: nil,
+						Stmts: []aops.StmtParam{
+							aops.StmtParam{
+								Kind: aops.AddReturnFuncWithVarStmt,
+								Stmt: []string{
+									`func(){
+                log.Println("before")
+            }()`,
+								},
+								Depends: []string{"err"},
+								Source:  aops.Source{File: subFile.Name(), Line: 4},
+							},
+							aops.StmtParam{
+								Kind: aops.AddReturnFuncWithoutVarStmt,
+								Stmt: []string{
+									`func(){
+                log.Println("before")
+            }()`,
+								},
+								Depends: nil,
+								Source:  aops.Source{File: subFile.Name(), Line: 10},
+							},
+						},
+						Packs: nil,
+					},
+					"@middleware-a": aops.StmtParams{
+						DeclStmt: nil,
+						Stmts: []aops.StmtParam{
+							aops.StmtParam{
+								Kind: aops.AddFuncWithoutDepends,
+								Stmt: []string{
+									`func(){
+                log.Println("before")
+            }()`,
+								},
+								Depends: nil,
+								Source:  aops.Source{File: f.Name(), Line: 5},
+							},
+							aops.StmtParam{
+								Kind: aops.AddFuncWithVarStmt,
+								Stmt: []string{
+									`func(){
+                log.Println("before")
+            }()`,
+								},
+								Depends: []string{"str"},
+								Source:  aops.Source{File: f.Name(), Line: 10},
+							},
+						},
+						Packs: []aops.Pack{
+							aops.Pack{
+								Name: "log",
+								Path: "\"github.com/sirupsen/logrus\"",
+							},
+						},
+					},
+				},
+			},
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotC, err := Parse(tt.args.file)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(gotC, tt.wantC) {
+				t.Errorf("Parse() gotC = %v, want %v", gotC, tt.wantC)
+			}
+		})
+	}
+}
+
+func Test_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    string
+		wantErr ErrorList
+	}{
+		{
+			name: "valid config",
+			conf: `
+[[middleware]]
+    id="@middleware-a"
+    [[middleware.Stmt]]
+    kind="add-defer-func"
+    code=["""defer func(){
+                log.Println("after")
+            }()"""]
+    [[middleware.Stmt]]
+    kind="add-func-with-var-depend"
+    code=["fmt.Println(err)"]
+    depend=["err"]
+`,
+			wantErr: nil,
+		},
+		{
+			name: "kind not woven and duplicate kind",
+			conf: `
+[[middleware]]
+    id="@middleware-a"
+    [[middleware.Stmt]]
+    kind="add-defer-func-with-var-depend"
+    code=["defer fmt.Println(err)"]
+    depend=["err"]
+    [[middleware.Stmt]]
+    kind="add-defer-func"
+    code=["defer a()"]
+    [[middleware.Stmt]]
+    kind="add-defer-func"
+    code=["defer b()"]
+`,
+			wantErr: ErrorList{
+				{Line: 4, Msg: `middleware @middleware-a: kind "add-defer-func-with-var-depend" is not woven`},
+				{Line: 11, Msg: `middleware @middleware-a: duplicate kind "add-defer-func", first declared at line 8, only the first one is woven`},
+			},
+		},
+		{
+			name: "misspelled kind",
+			conf: `
+[[middleware]]
+    id="@middleware-a"
+    [[middleware.Stmt]]
+    kind="add-defer-fun"
+    code=["defer func(){}()"]
+`,
+			wantErr: ErrorList{
+				{Line: 4, Msg: `middleware @middleware-a: unknown kind "add-defer-fun"`},
+			},
+		},
+		{
+			name: "missing code and ignored depend",
+			conf: `
+[[middleware]]
+    id="@middleware-a"
+    [[middleware.Stmt]]
+    kind="add-func-without-depends"
+    depend=["err"]
+    funDepend=["math.Round"]
+    [[middleware.Stmt]]
+    kind="add-return-func-with-var"
+    code=["fmt.Println(err)"]
+`,
+			wantErr: ErrorList{
+				{Line: 4, Msg: `middleware @middleware-a: kind "add-func-without-depends" has no code`},
+				{Line: 4, Msg: `middleware @middleware-a: kind "add-func-without-depends" ignores depend`},
+				{Line: 4, Msg: `middleware @middleware-a: kind "add-func-without-depends" ignores funDepend`},
+				{Line: 8, Msg: `middleware @middleware-a: kind "add-return-func-with-var" needs depend`},
+			},
+		},
+		{
+			name: "duplicate id",
+			conf: `
+[[middleware]]
+    id="@middleware-a"
+    [[middleware.Stmt]]
+    kind="add-defer-func"
+    code=["""defer func() { /*
+    [[middleware]]
+    */ }()"""]
+[[middleware]]
+    id="@middleware-a"
+`,
+			wantErr: ErrorList{
+				{Line: 9, Msg: `duplicate middleware id "@middleware-a", first declared at line 2`},
+			},
+		},
+		{
+			name: "invalid param",
+			conf: `
+[[middleware]]
+    id="@middleware-a"
+    [[middleware.param]]
+    name="qps"
+    type="int"
+    default="1.5"
+    [[middleware.Stmt]]
+    kind="add-func-without-depends-with-injection"
+    code=["fmt.Println(qps)"]
+`,
+			wantErr: ErrorList{
+				{Line: 2, Msg: "middleware @middleware-a: param[0] qps: default value: float value can not be used as int"},
+			},
+		},
+		{
+			name: "invalid code",
+			conf: `
+[[middleware]]
+    id="@middleware-a"
+    [[middleware.Stmt]]
+    kind="add-defer-func"
+    code=["defer fmt.Println()", "defer fmt.Println(1 2)"]
+`,
+			wantErr: ErrorList{
+				{Line: 4, Msg: "missing ',' in argument list", Err: &aops.SnippetError{
+					ID:      "@middleware-a",
+					Stmt:    0,
+					Code:    1,
+					Source:  aops.Source{Line: 4},
+					Snippet: "defer fmt.Println(1 2)",
+					Pos:     token.Position{Offset: 20, Line: 1, Column: 21},
+					Msg:     "missing ',' in argument list",
+				}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, _ := os.CreateTemp("", "")
+			defer os.Remove(f.Name())
+			os.WriteFile(f.Name(), []byte(tt.conf), 0777)
+
+			_, err := Parse(f.Name())
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("Parse() error = %v, want nil", err)
+				}
+				return
+			}
+
+			for i := range tt.wantErr {
+				tt.wantErr[i].File = f.Name()
+				if se, ok := tt.wantErr[i].Err.(*aops.SnippetError); ok {
+					se.Source.File = f.Name()
+				}
+			}
+			if !reflect.DeepEqual(err, tt.wantErr) {
+				t.Errorf("Parse() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseInclude(t *testing.T) {
+	middleware := func(id, code string) string {
+		return fmt.Sprintf(`
+[[middleware]]
+    id="%s"
+    [[middleware.Stmt]]
+    kind="add-func-without-depends"
+    code=["%s"]
+`, id, code)
+	}
+
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "nested relative include",
+			files: map[string]string{
+				"aop.toml":                `include=["aspects/trace.toml"]` + middleware("@a", "a()"),
+				"aspects/trace.toml":      `include=["common/log.toml"]` + middleware("@trace", "trace()"),
+				"aspects/common/log.toml": middleware("@log", "log()"),
+			},
+			want: map[string]string{"@a": "a()", "@trace": "trace()", "@log": "log()"},
+		},
+		{
+			name: "glob include and collision",
+			files: map[string]string{
+				"aop.toml":       `include=["aspects/*.toml"]` + middleware("@a", "a()"),
+				"aspects/1.toml": middleware("@a", "a1()") + middleware("@b", "b1()"),
+				"aspects/2.toml": middleware("@b", "b2()"),
+				"aspects/2.yaml": "not a toml file",
+			},
+			want: map[string]string{"@a": "a()", "@b": "b2()"},
+		},
+		{
+			name: "include cycle",
+			files: map[string]string{
+				"aop.toml": `include=["a/a.toml"]`,
+				"a/a.toml": `include=["../b.toml"]`,
+				"b.toml":   `include=["aop.toml"]`,
+			},
+			wantErr: true,
+		},
+		{
+			name: "include not exist",
+			files: map[string]string{
+				"aop.toml": `include=["not-exist.toml"]`,
+			},
+			wantErr: true,
+		},
+		{
+			name: "included file is invalid",
+			files: map[string]string{
+				"aop.toml": `include=["a.toml"]` + middleware("@a", "a()"),
+				"a.toml":   strings.Replace(middleware("@b", "b()"), "without-depends", "without-depend", 1),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				name = filepath.Join(dir, name)
+				os.MkdirAll(filepath.Dir(name), 0777)
+				os.WriteFile(name, []byte(content), 0666)
+			}
+
+			c, err := Parse(filepath.Join(dir, "aop.toml"))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+
+			got := make(map[string]string)
+			for id, sp := range c.MidWareMap {
+				got[id] = strings.Join(sp.Stmts[0].Stmt, "")
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	stmt, err := Load("../../example/aop.toml")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(Ids(stmt), map[string]struct{}{"@middleware-a": {}}) {
+		t.Errorf("Load() ids = %v", Ids(stmt))
+	}
+	if len(stmt["@middleware-a"].Stmts) != 2 || len(stmt["@middleware-a"].Packs) != 1 {
+		t.Errorf("Load() got = %+v", stmt["@middleware-a"])
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	files := map[string]string{
+		"aop.yaml": `
+include: ["aspects/trace.json", "aspects/log.toml"]
+middleware:
+  - id: "@a"
+    order: -1
+    Stmt:
+      - kind: add-defer-func
+        code: ["defer a()"]
+    package:
+      - name: log
+        path: '"github.com/sirupsen/logrus"'
+`,
+		"aspects/trace.json": `{
+  "middleware": [
+    {
+      "id": "@trace",
+      "Stmt": [
+        {"kind": "add-func-with-var-depend", "code": ["trace(err)"], "depend": ["err"]}
+      ]
+    }
+  ]
+}`,
+		"aspects/log.toml": `
+[[middleware]]
+    id="@log"
+    [[middleware.Stmt]]
+    kind="add-func-without-depends"
+    code=["log()"]
+    [[middleware.param]]
+    name="level"
+    type="string"
+    default='"info"'
+`,
+	}
+
+	dir := t.TempDir()
+	for name, content := range files {
+		name = filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(name), 0777)
+		os.WriteFile(name, []byte(content), 0666)
+	}
+
+	got, err := Load(filepath.Join(dir, "aop.yaml"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	want := map[string]aops.StmtParams{
+		"@a": {
+			Stmts: []aops.StmtParam{{Kind: aops.AddDeferFuncStmt, Stmt: []string{"defer a()"}, Source: aops.Source{File: filepath.Join(dir, "aop.yaml"), Line: 7}}},
+			Packs: []aops.Pack{{Name: "log", Path: `"github.com/sirupsen/logrus"`}},
+			Order: -1,
+		},
+		"@trace": {
+			Stmts: []aops.StmtParam{{Kind: aops.AddFuncWithVarStmt, Stmt: []string{"trace(err)"}, Depends: []string{"err"}, Source: aops.Source{File: filepath.Join(dir, "aspects/trace.json"), Line: 6}}},
+		},
+		"@log": {
+			Stmts:  []aops.StmtParam{{Kind: aops.AddFuncWithoutDepends, Stmt: []string{"log()"}, Source: aops.Source{File: filepath.Join(dir, "aspects/log.toml"), Line: 4}}},
+			Params: []aops.Param{{Name: "level", Type: "string", Default: `"info"`}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Load() got = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseFormatError(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		conf    string
+		wantErr ErrorList
+	}{
+		{
+			name: "yaml",
+			file: "aop.yml",
+			conf: `
+middleware:
+  - id: "@a"
+    Stmt:
+      - kind: add-defer-func
+        code: ["defer a()"]
+      - kind: add-defer-fun
+        code: ["defer a()"]
+  - id: "@a"
+`,
+			wantErr: ErrorList{
+				{Line: 7, Msg: `middleware @a: unknown kind "add-defer-fun"`},
+				{Line: 9, Msg: `duplicate middleware id "@a", first declared at line 3`},
+			},
+		},
+		{
+			name: "json",
+			file: "aop.json",
+			conf: `{"middleware": [
+  {"id": "@a", "Stmt": [
+    {"kind": "add-defer-func", "code": ["defer a()"], "depend": ["err"]}
+  ]}
+]}`,
+			wantErr: ErrorList{
+				{Line: 3, Msg: `middleware @a: kind "add-defer-func" ignores depend`},
+			},
+		},
+		{
+			name: "depend type",
+			file: "aop.toml",
+			conf: `
+[[middleware]]
+    id="@a"
+    [[middleware.Stmt]]
+    kind="add-func-with-var-depend"
+    code=["fmt.Println(__varName__)"]
+    type="context.Context"
+    [[middleware.Stmt]]
+    kind="add-defer-func"
+    code=["defer a()"]
+    type="context.Context"
+    [[middleware.Stmt]]
+    kind="add-func-with-var-depend"
+    code=["fmt.Println(__varName__)"]
+    depend=["ctx"]
+    type="context.Context"
+    [[middleware.Stmt]]
+    kind="add-return-func-with-var"
+    code=["fmt.Println(__varName__)"]
+    type="*http."
+`,
+			wantErr: ErrorList{
+				{Line: 8, Msg: `middleware @a: kind "add-defer-func" ignores type`},
+				{Line: 12, Msg: `middleware @a: duplicate kind "add-func-with-var-depend", first declared at line 4, only the first one is woven`},
+				{Line: 12, Msg: `middleware @a: type can not be used with depend or funDepend`},
+				{Line: 17, Msg: `middleware @a: bad type "*http.": 1:7: expected selector or type assertion, found 'EOF'`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), tt.file)
+			os.WriteFile(file, []byte(tt.conf), 0666)
+
+			_, err := Parse(file)
+			for i := range tt.wantErr {
+				tt.wantErr[i].File = file
+			}
+			if !reflect.DeepEqual(err, tt.wantErr) {
+				t.Errorf("Parse() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+var update = flag.Bool("update", false, "update the published schema")
+
+func TestParseFunc(t *testing.T) {
+	files := map[string]string{
+		"aop.toml": `
+[[middleware]]
+    id="@trace"
+    [[middleware.Stmt]]
+    kind="add-func-without-depends"
+    file="aspects/trace.go"
+    func="Before"
+    [[middleware.Stmt]]
+    kind="add-func-with-var-depend"
+    file="aspects/trace.go"
+    func="After"
+    depend=["err"]
+    [[middleware.Stmt]]
+    kind="add-defer-func"
+    file="aspects/trace.go"
+    func="Done"
+    [[middleware.package]]
+    name="log"
+    path='"github.com/sirupsen/logrus"'
+`,
+		"aspects/trace.go": `package aspects
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"strings"
+)
+
+func Before() {
+	log.Println("before")
+}
+
+func After(err error) {
+	// print the error
+	fmt.Println(strings.ToUpper(err.Error()))
+}
+
+func Done() {
+	log.Println("done")
+}
+`,
+	}
+
+	dir := t.TempDir()
+	for name, content := range files {
+		name = filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(name), 0777)
+		os.WriteFile(name, []byte(content), 0666)
+	}
+
+	got, err := Load(filepath.Join(dir, "aop.toml"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	source := func(line int) aops.Source {
+		return aops.Source{File: filepath.Join(dir, "aop.toml"), Line: line}
+	}
+	want := map[string]aops.StmtParams{
+		"@trace": {
+			Stmts: []aops.StmtParam{
+				{
+					Kind:   aops.AddFuncWithoutDepends,
+					Stmt:   []string{"func() {\n\tlog.Println(\"before\")\n}()"},
+					Source: source(4),
+				},
+				{
+					Kind:    aops.AddFuncWithVarStmt,
+					Stmt:    []string{"func() {\n\t// print the error\n\tfmt.Println(strings.ToUpper(err.Error()))\n}()"},
+					Depends: []string{"err"},
+					Source:  source(8),
+				},
+				{
+					Kind:   aops.AddDeferFuncStmt,
+					Stmt:   []string{"defer func() {\n\tlog.Println(\"done\")\n}()"},
+					Source: source(13),
+				},
+			},
+			Packs: []aops.Pack{
+				{Name: "log", Path: `"github.com/sirupsen/logrus"`},
+				{Path: `"fmt"`},
+				{Path: `"strings"`},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Load() got = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseFuncError(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "trace.go"), []byte("package aspects\n\nfunc Before() {}\n"), 0666)
+	conf := filepath.Join(dir, "aop.toml")
+	os.WriteFile(conf, []byte(`
+[[middleware]]
+    id="@trace"
+    [[middleware.Stmt]]
+    kind="add-func-without-depends"
+    file="trace.go"
+    func="After"
+    [[middleware.Stmt]]
+    kind="add-defer-func"
+    file="trace.go"
+    [[middleware.Stmt]]
+    kind="add-return-func-without-var"
+    code=["defer a()"]
+    file="trace.go"
+    func="Before"
+    [[middleware.Stmt]]
+    kind="add-around-ctx"
+    file="trace.go"
+    func="Before"
+`), 0666)
+
+	_, err := Parse(conf)
+	want := ErrorList{
+		{File: conf, Line: 4, Msg: "middleware @trace: " + filepath.Join(dir, "trace.go") + ": func After not found"},
+		{File: conf, Line: 8, Msg: `middleware @trace: file "trace.go" needs func`},
+		{File: conf, Line: 11, Msg: "middleware @trace: code and file can not be used together"},
+		{File: conf, Line: 16, Msg: `middleware @trace: kind "add-around-ctx" can not use file, the context declared in a closure does not reach the function`},
+	}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("Parse() error = %v, want %v", err, want)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	got, err := Schema()
+	if err != nil {
+		t.Fatalf("Schema() error = %v", err)
+	}
+
+	file := "../../example/aop.schema.json"
+	if *update {
+		os.WriteFile(file, append(got, '\n'), 0666)
+		return
+	}
+
+	want, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(append(got, '\n')) != string(want) {
+		t.Errorf("%s is out of date, run `go test -update` in aops/config", file)
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		conf    string
+		wantErr ErrorList
+	}{
+		{
+			name: "valid",
+			file: "aop.toml",
+			conf: `
+[[middleware]]
+    id="@a"
+    order=1
+    [[middleware.Stmt]]
+    kind="add-func-with-var-depend"
+    code=["fmt.Println(x)"]
+    depend=["x"]
+`,
+		},
+		{
+			name: "toml",
+			file: "aop.toml",
+			conf: `
+[[middleware]]
+    id="@a"
+    order="1"
+    [[middleware.Stmt]]
+    kind="add-defer-fun"
+    code=["defer a()"]
+    [[middleware.Stmt]]
+    kind="add-func-with-var-depend"
+    code=["a()"]
+    [[middleware.Stmt]]
+    kind="add-defer-func"
+    code=["defer a()"]
+    file="a.go"
+`,
+			wantErr: ErrorList{
+				{Line: 5, Msg: `schema: /middleware/0/Stmt/0/kind: add-defer-fun is not one of add-around-ctx, add-defer-func, add-func-with-var-depend, add-func-without-depends, add-func-without-depends-with-injection, add-return-func-with-var, add-return-func-without-var`},
+				{Line: 8, Msg: `schema: /middleware/0/Stmt/1: needs one of depend, funDepend, type`},
+				{Line: 11, Msg: `schema: /middleware/0/Stmt/2: file requires func`},
+				{Line: 11, Msg: `schema: /middleware/0/Stmt/2: needs exactly one of code, file`},
+				{Line: 2, Msg: `schema: /middleware/0/order: should be integer, got string`},
+			},
+		},
+		{
+			name: "yaml",
+			file: "aop.yaml",
+			conf: `
+middleware:
+  - id: "@a"
+    Stmt:
+      - kind: add-defer-func
+        code: ["defer a()"]
+        depend: ["x"]
+      - kind: add-around-ctx
+        file: a.go
+        func: Around
+  - Stmt: []
+`,
+			wantErr: ErrorList{
+				{Line: 5, Msg: `schema: /middleware/0/Stmt/0/depend: is not allowed`},
+				{Line: 8, Msg: `schema: /middleware/0/Stmt/1/file: is not allowed`},
+				{Line: 8, Msg: `schema: /middleware/0/Stmt/1/func: is not allowed`},
+				{Line: 8, Msg: `schema: /middleware/0/Stmt/1: code is required`},
+				{Line: 11, Msg: `schema: /middleware/1: id is required`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), tt.file)
+			os.WriteFile(file, []byte(tt.conf), 0666)
+
+			err := ValidateSchema(file)
+			for i := range tt.wantErr {
+				tt.wantErr[i].File = file
+			}
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("ValidateSchema() error = %v", err)
+				}
+				return
+			}
+			if !reflect.DeepEqual(err, tt.wantErr) {
+				t.Errorf("ValidateSchema() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// format is a config file format. All formats share the same schema, the keys are
+// the same as aop.toml.
+//
+// decode decodes data to c.
+// lines gets the line number of every middleware and Stmt table, stmtLines[i] saves the
+// lines of Stmt tables which belongs to the i-th middleware.
+type format struct {
+	decode func(data []byte, c *Config) error
+	lines  func(data []byte) (midLines []int, stmtLines [][]int, err error)
+}
+
+// All the decoders reject unknown keys, like the schema generated by `Schema`.
+var (
+	tomlFormat = format{
+		decode: func(data []byte, c *Config) error {
+			md, err := toml.Decode(string(data), c)
+			if err != nil {
+				return err
+			}
+
+			if undecoded := md.Undecoded(); len(undecoded) > 0 {
+				return fmt.Errorf("unknown keys %v", undecoded)
+			}
+			return nil
+		},
+		lines: tomlLines,
+	}
+	yamlFormat = format{
+		decode: func(data []byte, c *Config) error {
+			dec := yaml.NewDecoder(bytes.NewReader(data))
+			dec.KnownFields(true)
+			err := dec.Decode(c)
+			if err == io.EOF {
+				// empty file
+				return nil
+			}
+			return err
+		},
+		lines: yamlLines,
+	}
+	jsonFormat = format{
+		decode: func(data []byte, c *Config) error {
+			dec := json.NewDecoder(bytes.NewReader(data))
+			dec.DisallowUnknownFields()
+			return dec.Decode(c)
+		},
+		lines: jsonLines,
+	}
+)
+
+// getFormat choose the format by file extension. TOML is the default format.
+func getFormat(file string) format {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		return yamlFormat
+	case ".json":
+		return jsonFormat
+	default:
+		return tomlFormat
+	}
+}
+
+// tomlLines get the line number of every [[middleware]] and [[middleware.Stmt]] header.
+// The headers inside multi-line strings will be skipped.
+func tomlLines(data []byte) (midLines []int, stmtLines [][]int, err error) {
+	inString := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		wasInString := inString
+		if strings.Count(text, `"""`)%2 == 1 {
+			inString = !inString
+		}
+		if wasInString {
+			continue
+		}
+
+		header := strings.Join(strings.Fields(text), "")
+		switch {
+		case strings.HasPrefix(header, "[[middleware]]"):
+			midLines = append(midLines, line)
+			stmtLines = append(stmtLines, nil)
+		case strings.HasPrefix(header, "[[middleware.Stmt]]"):
+			if len(stmtLines) > 0 {
+				stmtLines[len(stmtLines)-1] = append(stmtLines[len(stmtLines)-1], line)
+			}
+		}
+	}
+
+	return midLines, stmtLines, scanner.Err()
+}
+
+// yamlLines get the line number of every item in `middleware` and `Stmt` sequences.
+func yamlLines(data []byte) (midLines []int, stmtLines [][]int, err error) {
+	var root yaml.Node
+	err = yaml.Unmarshal(data, &root)
+	if err != nil || len(root.Content) == 0 {
+		return
+	}
+
+	mids := yamlValue(root.Content[0], "middleware")
+	if mids == nil || mids.Kind != yaml.SequenceNode {
+		return
+	}
+
+	for _, m := range mids.Content {
+		var sl []int
+		if stmts := yamlValue(m, "Stmt"); stmts != nil && stmts.Kind == yaml.SequenceNode {
+			for _, s := range stmts.Content {
+				sl = append(sl, s.Line)
+			}
+		}
+
+		midLines = append(midLines, m.Line)
+		stmtLines = append(stmtLines, sl)
+	}
+
+	return
+}
+
+// yamlValue get the value node of key from mapping node n. Return nil if n has no key.
+func yamlValue(n *yaml.Node, key string) *yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+// jsonLines get the line number of every object in `middleware` and `Stmt` arrays.
+func jsonLines(data []byte) (midLines []int, stmtLines [][]int, err error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	lineAt := func(offset int64) int {
+		return bytes.Count(data[:offset], []byte("\n")) + 1
+	}
+
+	// walk reads a value. path is the keys from root to the value, like `.middleware[].Stmt[]`.
+	var walk func(path string) error
+	walk = func(path string) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		delim, ok := tok.(json.Delim)
+		if !ok {
+			return nil
+		}
+
+		switch delim {
+		case '{':
+			// InputOffset is the end of '{'
+			switch path {
+			case ".middleware[]":
+				midLines = append(midLines, lineAt(dec.InputOffset()-1))
+				stmtLines = append(stmtLines, nil)
+			case ".middleware[].Stmt[]":
+				if len(stmtLines) > 0 {
+					stmtLines[len(stmtLines)-1] = append(stmtLines[len(stmtLines)-1], lineAt(dec.InputOffset()-1))
+				}
+			}
+
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				if err := walk(path + "." + key.(string)); err != nil {
+					return err
+				}
+			}
+		case '[':
+			for dec.More() {
+				if err := walk(path + "[]"); err != nil {
+					return err
+				}
+			}
+		}
+
+		// read the end '}' or ']'
+		_, err = dec.Token()
+		return err
+	}
+
+	err = walk("")
+	return
+}
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"fmt"
+	"github.com/runways/goAOP/aops"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// loadFuncs load the code of every Stmt which declares `file` and `func`, and save it in Stmt.Code.
+// The imports used by the function are appended to the Package of its middleware, so the
+// manual [[middleware.package]] list is not needed.
+//
+// The file path is relative to the config file, like the include paths. The body of function
+// is wrapped as `func() {...}()`, or `defer func() {...}()` for the defer kinds, so `add-around-ctx`
+// can not use it, `validate` reports that. The parameters
+// of function are not declared in the snippet, so they can stand for the depended variables,
+// which makes the function compile alone:
+//
+//	// Trace is used by add-func-with-var-depend, depend=["err"]
+//	func Trace(err error) {
+//		log.Println(err)
+//	}
+func loadFuncs(file string, c *Config, stmtLines [][]int) (errs ErrorList) {
+	dir := filepath.Dir(file)
+	for i := range c.MidWare {
+		m := &c.MidWare[i]
+		for j := range m.Stmt {
+			s := &m.Stmt[j]
+			if s.File == "" && s.Func == "" {
+				continue
+			}
+
+			line := 0
+			if i < len(stmtLines) && j < len(stmtLines[i]) {
+				line = stmtLines[i][j]
+			}
+			report := func(format string, a ...interface{}) {
+				errs = append(errs, Error{
+					File: file,
+					Line: line,
+					Msg:  fmt.Sprintf("middleware %s: %s", strings.TrimSpace(m.ID), fmt.Sprintf(format, a...)),
+				})
+			}
+
+			switch {
+			case s.File == "":
+				report("func %q needs file", s.Func)
+				continue
+			case s.Func == "":
+				report("file %q needs func", s.File)
+				continue
+			case len(s.Code) > 0:
+				report("code and file can not be used together")
+				continue
+			}
+
+			path := s.File
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+			body, packs, err := parseFunc(path, s.Func)
+			if err != nil {
+				report("%v", err)
+				continue
+			}
+
+			switch stmtKinds[strings.TrimSpace(strings.ToLower(s.Kind))].kind {
+			case aops.AddDeferFuncStmt:
+				s.Code = []string{"defer func() {" + body + "}()"}
+			default:
+				s.Code = []string{"func() {" + body + "}()"}
+			}
+			m.Package = mergePacks(m.Package, packs)
+		}
+	}
+
+	return
+}
+
+// parseFunc get the body of function name in file, and the imports which the body uses.
+// The body is the source between `{` and `}`, so the format and comments are kept.
+func parseFunc(file, name string) (body string, packs []Pack, err error) {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return
+	}
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, src, parser.ParseComments)
+	if err != nil {
+		return
+	}
+
+	var fd *ast.FuncDecl
+	for _, d := range f.Decls {
+		if _fd, ok := d.(*ast.FuncDecl); ok && _fd.Recv == nil && _fd.Name.Name == name {
+			fd = _fd
+			break
+		}
+	}
+	if fd == nil || fd.Body == nil {
+		return "", nil, fmt.Errorf("%s: func %s not found", file, name)
+	}
+
+	tf := fset.File(fd.Pos())
+	body = string(src[tf.Offset(fd.Body.Lbrace)+1 : tf.Offset(fd.Body.Rbrace)])
+
+	// the names used as `x` in `x.Sel`, they may be the imported packages.
+	used := make(map[string]bool)
+	ast.Inspect(fd.Body, func(n ast.Node) bool {
+		if se, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := se.X.(*ast.Ident); ok {
+				used[id.Name] = true
+			}
+		}
+		return true
+	})
+
+	for _, i := range f.Imports {
+		p := Pack{Path: i.Path.Value}
+		if i.Name != nil {
+			p.Name = i.Name.Name
+		}
+
+		if used[importName(i)] {
+			packs = append(packs, p)
+		}
+	}
+
+	return
+}
+
+// importName get the name which the file uses to refer the import. If the import has no name, guess
+// it from the path: the last element, or the one before it if the last element is a major version, like `v2`.
+func importName(i *ast.ImportSpec) string {
+	if i.Name != nil {
+		return i.Name.Name
+	}
+
+	path, _ := strconv.Unquote(i.Path.Value)
+	elems := strings.Split(path, "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && len(name) > 1 && name[0] == 'v' {
+		if _, err := strconv.Atoi(name[1:]); err == nil {
+			name = elems[len(elems)-2]
+		}
+	}
+
+	return name
+}
+
+// mergePacks append the packs not in dst to dst.
+func mergePacks(dst, packs []Pack) []Pack {
+	for _, p := range packs {
+		exist := false
+		for _, d := range dst {
+			if strings.TrimSpace(d.Name) == p.Name && strings.TrimSpace(d.Path) == p.Path {
+				exist = true
+				break
+			}
+		}
+		if !exist {
+			dst = append(dst, p)
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Schema generate the JSON Schema (draft-07) of the config file from Config.
+//
+// The keys come from the json tags, which are the same as toml and yaml tags. The fields
+// tagged with `schema:"required"` are required. The valid kinds and the keys every kind
+// honours come from the same table as the validation of `Parse`. So the schema and `Parse`
+// always agree, except `Parse` also checks whether the code can be parsed.
+func Schema() ([]byte, error) {
+	s := typeSchema(reflect.TypeOf(Config{}))
+	s["$schema"] = "http://json-schema.org/draft-07/schema#"
+	s["title"] = "goAOP aspect config"
+
+	return json.MarshalIndent(s, "", "  ")
+}
+
+// typeSchema generate schema of t. The struct fields without json tag are ignored.
+func typeSchema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Slice:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": typeSchema(t.Elem()),
+		}
+	case reflect.Struct:
+		props := make(map[string]interface{})
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+
+			props[name] = typeSchema(f.Type)
+			if f.Tag.Get("schema") == "required" {
+				required = append(required, name)
+			}
+		}
+
+		s := map[string]interface{}{
+			"type":                 "object",
+			"properties":           props,
+			"additionalProperties": false,
+		}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		if t == reflect.TypeOf(Stmt{}) {
+			addKindSchema(s)
+		}
+
+		return s
+	}
+
+	return map[string]interface{}{}
+}
+
+// addKindSchema add the valid kinds to Stmt schema, and the required and forbidden keys of every kind.
+// `file` and `func` must be used together, and the code only kinds can not use them.
+func addKindSchema(s map[string]interface{}) {
+	kinds := make([]string, 0, len(stmtKinds))
+	for k := range stmtKinds {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+
+	s["properties"].(map[string]interface{})["kind"] = map[string]interface{}{
+		"type": "string",
+		"enum": kinds,
+	}
+
+	var rules []interface{}
+	for _, k := range kinds {
+		spec := stmtKinds[k]
+		props := make(map[string]interface{})
+		if !spec.depend {
+			props["depend"] = false
+			props["type"] = false
+		}
+		if !spec.funDepend {
+			props["funDepend"] = false
+		}
+
+		// the code is declared by `code` or `file`, but not both.
+		then := map[string]interface{}{
+			"oneOf": []interface{}{
+				map[string]interface{}{"required": []string{"code"}},
+				map[string]interface{}{"required": []string{"file"}},
+			},
+		}
+		if spec.codeOnly {
+			props["file"] = false
+			props["func"] = false
+			then = map[string]interface{}{"required": []string{"code"}}
+		}
+		if len(props) > 0 {
+			then["properties"] = props
+		}
+		if spec.needDepend {
+			then["anyOf"] = []interface{}{
+				map[string]interface{}{"required": []string{"depend"}},
+				map[string]interface{}{"required": []string{"funDepend"}},
+				map[string]interface{}{"required": []string{"type"}},
+			}
+		}
+
+		rules = append(rules, map[string]interface{}{
+			"if": map[string]interface{}{
+				"properties": map[string]interface{}{
+					"kind": map[string]interface{}{"const": k},
+				},
+			},
+			"then": then,
+		})
+	}
+
+	s["allOf"] = rules
+	s["dependencies"] = map[string]interface{}{
+		"file": []string{"func"},
+		"func": []string{"file"},
+	}
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"fmt"
+	"github.com/runways/goAOP/aops"
+	"go/parser"
+	"strings"
+)
+
+// kindSpec describes how a `kind` value in [[middleware.Stmt]] is handled.
+// depend and funDepend mark whether the kind honours these keys, needDepend
+// marks the kinds which do nothing unless one of them is set. codeOnly marks the kinds which can not
+// load `file` and `func`, since the body of function is wrapped as a closure.
+type kindSpec struct {
+	kind       aops.OperationKind
+	depend     bool
+	funDepend  bool
+	needDepend bool
+	codeOnly   bool
+}
+
+// stmtKinds are all the valid kinds, keep it same as `aops/const.go`. The kinds which no operator
+// weaves are not valid, like `add-defer-func-with-var-depend`.
+var stmtKinds = map[string]kindSpec{
+	aops.AddFuncWithoutDependsStr:           {kind: aops.AddFuncWithoutDepends},
+	aops.AddFuncWithoutDependsWithInjectStr: {kind: aops.AddFuncWithoutDependsWithInject},
+	aops.AddFuncWithVarStmtStr:              {kind: aops.AddFuncWithVarStmt, depend: true, funDepend: true, needDepend: true},
+	aops.AddDeferFuncStmtStr:                {kind: aops.AddDeferFuncStmt},
+	aops.AddReturnFuncWithoutVarStmtStr:     {kind: aops.AddReturnFuncWithoutVarStmt},
+	aops.AddReturnFuncWithVarStmtStr:        {kind: aops.AddReturnFuncWithVarStmt, depend: true, needDepend: true},
+	aops.AddAroundCtxStmtStr:                {kind: aops.AddAroundCtxStmt, codeOnly: true},
+}
+
+// Error is a config mistake, File and Line point to the table
+// that contains the mistake. Err is the detail error if it has,
+// like *aops.SnippetError for the code can not be parsed.
+type Error struct {
+	File string
+	Line int
+	Msg  string
+	Err  error
+}
+
+func (e Error) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s", e.File, e.Msg)
+}
+
+// ErrorList is a list of Error, validate reports all mistakes at once.
+type ErrorList []Error
+
+func (es ErrorList) Error() string {
+	msg := make([]string, 0, len(es))
+	for _, e := range es {
+		msg = append(msg, e.Error())
+	}
+
+	return strings.Join(msg, "\n")
+}
+
+// validate check every middleware declared in file. It reports invalid params, unknown kinds,
+// stmt without code, code can not be parsed, depend/funDepend/type/file supplied to kinds which ignore them,
+// var kinds without any depend, the kinds which are not woven, duplicate middleware ids and duplicate kinds
+// in a middleware, since only the first stmt of a kind is woven.
+// midLines and stmtLines are the table lines got by `format.lines`.
+// Return nil if c is valid, otherwise return ErrorList.
+func validate(file string, c Config, midLines []int, stmtLines [][]int) error {
+	lineOf := func(lines []int, idx int) int {
+		if idx < len(lines) {
+			return lines[idx]
+		}
+		return 0
+	}
+
+	var errs ErrorList
+	report := func(line int, format string, a ...interface{}) {
+		errs = append(errs, Error{
+			File: file,
+			Line: line,
+			Msg:  fmt.Sprintf(format, a...),
+		})
+	}
+
+	ids := make(map[string]int)
+	for i, m := range c.MidWare {
+		line := lineOf(midLines, i)
+
+		id := strings.TrimSpace(m.ID)
+		if id == "" {
+			report(line, "middleware has no id")
+		} else if first, exist := ids[id]; exist {
+			report(line, "duplicate middleware id %q, first declared at line %d", id, first)
+		} else {
+			ids[id] = line
+		}
+
+		if err := aops.CheckParams(id, convertParams(m.Param)); err != nil {
+			report(line, "%v", err)
+		}
+
+		var sl []int
+		if i < len(stmtLines) {
+			sl = stmtLines[i]
+		}
+		kinds := make(map[string]int)
+		for j, s := range m.Stmt {
+			line := lineOf(sl, j)
+			kind := strings.TrimSpace(strings.ToLower(s.Kind))
+			spec, exist := stmtKinds[kind]
+			switch {
+			case kind == aops.AddDeferFuncWithVarStmtStr:
+				report(line, "middleware %s: kind %q is not woven", id, kind)
+				continue
+			case !exist:
+				report(line, "middleware %s: unknown kind %q", id, s.Kind)
+				continue
+			}
+			if first, exist := kinds[kind]; exist {
+				report(line, "middleware %s: duplicate kind %q, first declared at line %d, only the first one is woven", id, kind, first)
+			} else {
+				kinds[kind] = line
+			}
+
+			// the mistakes of file are reported by loadFuncs
+			if len(s.Code) == 0 && s.File == "" {
+				report(line, "middleware %s: kind %q has no code", id, kind)
+			}
+			if s.File != "" && spec.codeOnly {
+				report(line, "middleware %s: kind %q can not use file, the context declared in a closure does not reach the function", id, kind)
+			}
+			if len(s.Depend) > 0 && !spec.depend {
+				report(line, "middleware %s: kind %q ignores depend", id, kind)
+			}
+			if len(s.FunDepend) > 0 && !spec.funDepend {
+				report(line, "middleware %s: kind %q ignores funDepend", id, kind)
+			}
+			if s.Type != "" {
+				switch {
+				case !spec.depend:
+					report(line, "middleware %s: kind %q ignores type", id, kind)
+				case len(s.Depend) > 0 || len(s.FunDepend) > 0:
+					report(line, "middleware %s: type can not be used with depend or funDepend", id)
+				default:
+					if _, err := parser.ParseExpr(s.Type); err != nil {
+						report(line, "middleware %s: bad type %q: %v", id, s.Type, err)
+					}
+				}
+			}
+			if spec.needDepend && len(s.Depend) == 0 && len(s.FunDepend) == 0 && s.Type == "" {
+				report(line, "middleware %s: kind %q needs depend", id, kind)
+			}
+			err := aops.CheckStmt(id, j, aops.StmtParam{
+				Kind:   spec.kind,
+				Stmt:   s.Code,
+				Source: aops.Source{File: file, Line: line},
+			})
+			if se, ok := err.(*aops.SnippetError); ok {
+				errs = append(errs, Error{
+					File: file,
+					Line: line,
+					Msg:  se.Msg,
+					Err:  se,
+				})
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ const (
 	AddReturnFuncWithoutVarStmt
 	AddReturnFuncWithVarStmt
 	AddFuncWithoutDependsWithInject
+	AddAroundCtxStmt
 )
 
 const (
@@ -18,12 +19,25 @@ const (
 	AddDeferFuncWithVarStmtStr         = "add-defer-func-with-var-depend"
 	AddReturnFuncWithoutVarStmtStr     = "add-return-func-without-var"
 	AddReturnFuncWithVarStmtStr        = "add-return-func-with-var"
+	AddAroundCtxStmtStr                = "add-around-ctx"
 )
 
 const (
 	aopInjectLabel = "@inject"
+	// aopJoinPointType is the param type which makes `@inject` populate inject.JoinPoint instead of inject.AOPLabel.
+	aopJoinPointType = "inject.JoinPoint"
 )
 
 const (
 	funcDependVarPlaceHolderVarName = "__varName__"
+	// funcArgsPlaceHolder expands to the name/value pairs of function parameters, like `[]inject.Arg{...}`.
+	funcArgsPlaceHolder = "__args__"
+	// sensitiveDirective marks the parameters which `__args__` skips, like `//goaop:sensitive password token`.
+	sensitiveDirective = "//goaop:sensitive"
+	// funcCtxPlaceHolder expands to the context of function, like `ctx`, `r.Context()` or `context.Background()`.
+	funcCtxPlaceHolder = "__ctx__"
+	// aroundCtxVarName is the variable declared by AddAroundCtxStmt when the function has no context parameter.
+	aroundCtxVarName = "aopCtx"
+	// injectImportPath is the package of `inject.Arg`, which the code of `__args__` uses.
+	injectImportPath = `"github.com/runways/goAOP/inject"`
 )
@@ -0,0 +1,240 @@
+package aops
+
+import (
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"strings"
+)
+
+// bindDependType bind the stmts of sp which declare DependType to the first variable of that type, and
+// replace `__varName__` in them with the variable name. So one aspect works for `ctx`, `c` or `reqCtx`.
+//
+// The variable is found in the parameters, then the top level declarations of body. The types are got from
+// info, which is the type check of package before weaving, so the local variable assigned by a call, like
+// `reqCtx := r.Context()` or `c, cancel := context.WithTimeout(ctx, d)`, and the type of an aliased import
+// are found too. If info has no type of variable, e.g. the package can not be type-checked, the type is known
+// only if it is declared explicitly, like `var c context.Context`, or by a composite literal, like
+// `c := &http.Client{}` and `c := new(http.Client)`. AddReturnFuncWithVarStmt finds it in the returned function.
+//
+// The found local variable becomes the Depends of stmt. The code bound to a parameter of fd is returned
+// in heads, key is the index of stmt, since it is inserted in the head of body. If no variable has the
+// type, an error is returned, since the code of aspect is not woven.
+// sp should be the copy returned by `renderStmtParams`, since it is changed in place.
+func bindDependType(fd *ast.FuncDecl, sp StmtParams, info *types.Info) (heads map[int][]string, err error) {
+	heads = make(map[int][]string)
+	for i := range sp.Stmts {
+		s := &sp.Stmts[i]
+		if s.DependType == "" {
+			continue
+		}
+
+		params, body := fd.Type.Params, fd.Body.List
+		if s.Kind == AddReturnFuncWithVarStmt {
+			params, body = nil, nil
+			if rf := returnFuncLit(fd); rf != nil {
+				params, body = rf.Type.Params, rf.Body.List
+			}
+		}
+
+		name, isParam := findVarByType(params, body, normalizeType(s.DependType), info)
+		if name == "" {
+			return nil, fmt.Errorf("Stmt[%d]: no variable of type %s in %s", i, s.DependType, fd.Name.Name)
+		}
+
+		code := make([]string, len(s.Stmt))
+		for k := range s.Stmt {
+			code[k] = strings.Replace(s.Stmt[k], funcDependVarPlaceHolderVarName, name, -1)
+		}
+
+		if isParam && s.Kind != AddReturnFuncWithVarStmt {
+			heads[i] = code
+			s.Stmt, s.Depends = nil, nil
+			continue
+		}
+		s.Stmt, s.Depends = code, []string{name}
+	}
+
+	return
+}
+
+// hasDependType check whether any stmt of sp declares DependType.
+func hasDependType(sp StmtParams) bool {
+	for _, s := range sp.Stmts {
+		if s.DependType != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// typeInfo type-check the package of file f before weaving, the other files of package are parsed into fset.
+// The errors are ignored, since the types of valid code are still recorded.
+func typeInfo(fset *token.FileSet, file string, f *ast.File) *types.Info {
+	others, err := packageFiles(fset, filepath.Dir(file), f.Name.Name, func(name string) bool {
+		return name == filepath.Base(file)
+	})
+	if err != nil {
+		return nil
+	}
+
+	info := &types.Info{Defs: make(map[*ast.Ident]types.Object)}
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		Error:    func(error) {},
+	}
+	conf.Check(packagePath(file, f.Name.Name), fset, append([]*ast.File{f}, others...), info)
+
+	return info
+}
+
+// returnFuncLit get the function literal returned by the first return stmt of fd, like `addStmtAsReturnOperator`.
+func returnFuncLit(fd *ast.FuncDecl) *ast.FuncLit {
+	for _, s := range fd.Body.List {
+		if rs, ok := s.(*ast.ReturnStmt); ok {
+			for _, r := range rs.Results {
+				if rf, ok := r.(*ast.FuncLit); ok {
+					return rf
+				}
+			}
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// findVarByType find the first variable of typ in params, then in the top level stmts of body.
+// The type of variable is got from info, or from its declaration if info has no type of it.
+func findVarByType(params *ast.FieldList, body []ast.Stmt, typ string, info *types.Info) (name string, isParam bool) {
+	match := func(id *ast.Ident, declared string) bool {
+		if id.Name == "_" {
+			return false
+		}
+		if t := objectType(info, id); t != "" {
+			return t == typ
+		}
+		return declared == typ
+	}
+
+	if params != nil {
+		for _, f := range params.List {
+			for _, n := range f.Names {
+				if match(n, typeString(f.Type)) {
+					return n.Name, true
+				}
+			}
+		}
+	}
+
+	for _, s := range body {
+		switch t := s.(type) {
+		case *ast.DeclStmt:
+			gd, ok := t.Decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.VAR {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				vs := spec.(*ast.ValueSpec)
+				for k, n := range vs.Names {
+					declared := ""
+					switch {
+					case vs.Type != nil:
+						declared = typeString(vs.Type)
+					case k < len(vs.Values):
+						declared = typeOfValue(vs.Values[k])
+					}
+					if match(n, declared) {
+						return n.Name, false
+					}
+				}
+			}
+		case *ast.AssignStmt:
+			if t.Tok != token.DEFINE {
+				continue
+			}
+			for k, l := range t.Lhs {
+				id, ok := l.(*ast.Ident)
+				if !ok {
+					continue
+				}
+				declared := ""
+				if len(t.Lhs) == len(t.Rhs) {
+					declared = typeOfValue(t.Rhs[k])
+				}
+				if match(id, declared) {
+					return id.Name, false
+				}
+			}
+		}
+	}
+
+	return "", false
+}
+
+// objectType get the type of variable id declares in info, the packages are qualified by their names, like
+// `context.Context`, and the types of its own package are not qualified. Return empty string if it is unknown.
+func objectType(info *types.Info, id *ast.Ident) string {
+	if info == nil {
+		return ""
+	}
+	obj := info.Defs[id]
+	if obj == nil {
+		return ""
+	}
+	if b, ok := obj.Type().(*types.Basic); ok && b.Kind() == types.Invalid {
+		return ""
+	}
+
+	typ := types.TypeString(obj.Type(), func(p *types.Package) string {
+		if p == obj.Pkg() {
+			return ""
+		}
+		return p.Name()
+	})
+	// a part of type is not resolved, like the package can not be imported.
+	if strings.Contains(typ, "invalid type") {
+		return ""
+	}
+
+	return typ
+}
+
+// typeOfValue get the type of composite literal `T{}`, `&T{}` and `new(T)`, or empty string for other values.
+func typeOfValue(e ast.Expr) string {
+	switch v := e.(type) {
+	case *ast.CompositeLit:
+		if v.Type != nil {
+			return typeString(v.Type)
+		}
+	case *ast.UnaryExpr:
+		if lit, ok := v.X.(*ast.CompositeLit); ok && v.Op == token.AND && lit.Type != nil {
+			return "*" + typeString(lit.Type)
+		}
+	case *ast.CallExpr:
+		if id, ok := v.Fun.(*ast.Ident); ok && id.Name == "new" && len(v.Args) == 1 {
+			return "*" + typeString(v.Args[0])
+		}
+	}
+
+	return ""
+}
+
+// normalizeType format typ like `typeString`, so `* http.Request` equals to `*http.Request`.
+func normalizeType(typ string) string {
+	e, err := parser.ParseExpr(typ)
+	if err != nil {
+		return strings.TrimSpace(typ)
+	}
+
+	return typeString(e)
+}
+
+func typeString(e ast.Expr) string {
+	return exprString(token.NewFileSet(), e)
+}
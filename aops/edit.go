@@ -0,0 +1,410 @@
+package aops
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/scanner"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// textEdit inserts text at offset of source, or replaces the source between offset and end if end is
+// larger than offset.
+type textEdit struct {
+	offset int
+	end    int
+	text   string
+}
+
+// applyEdits apply edits to src, every byte out of edits is kept.
+func applyEdits(src []byte, edits []textEdit) []byte {
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].offset < edits[j].offset
+	})
+
+	var buf bytes.Buffer
+	last := 0
+	for _, e := range edits {
+		buf.Write(src[last:e.offset])
+		buf.WriteString(e.text)
+		last = e.offset
+		if e.end > e.offset {
+			last = e.end
+		}
+	}
+	buf.Write(src[last:])
+
+	return buf.Bytes()
+}
+
+// indentUnit get the indent of src: tab if any line is indented by tab, otherwise the least spaces
+// which indent a line. Tab is the default.
+func indentUnit(src []byte) string {
+	spaces := 0
+	for _, line := range strings.Split(string(src), "\n") {
+		if strings.HasPrefix(line, "\t") {
+			return "\t"
+		}
+		n := len(line) - len(strings.TrimLeft(line, " "))
+		if n > 0 && n < len(line) && (spaces == 0 || n < spaces) {
+			spaces = n
+		}
+	}
+	if spaces == 0 {
+		return "\t"
+	}
+
+	return strings.Repeat(" ", spaces)
+}
+
+// stmtEditor computes the edits which insert the woven stmts into the original source.
+type stmtEditor struct {
+	fset    *token.FileSet
+	file    *token.File
+	f       *ast.File
+	src     []byte
+	unit    string
+	origins map[ast.Stmt]stmtOrigin
+	edits   []textEdit
+}
+
+// insertionEdits get the edits which insert the stmts of origins into src, f is parsed from src and woven.
+// The inserted stmts are printed alone, and indented like the stmts around them, so the original source
+// keeps its format and comments.
+func insertionEdits(fset *token.FileSet, f *ast.File, src []byte, origins map[ast.Stmt]stmtOrigin) []textEdit {
+	e := &stmtEditor{
+		fset:    fset,
+		file:    fset.File(f.Pos()),
+		f:       f,
+		src:     src,
+		unit:    indentUnit(src),
+		origins: origins,
+	}
+
+	ast.Inspect(f, func(n ast.Node) bool {
+		if s, ok := n.(ast.Stmt); ok {
+			if _, injected := origins[s]; injected {
+				return false
+			}
+		}
+		if b, ok := n.(*ast.BlockStmt); ok {
+			e.block(b)
+		}
+		return true
+	})
+
+	return e.edits
+}
+
+// block add the edits of the inserted stmts in b. A group of inserted stmts is put before the next
+// original stmt and its comments, or before the `}` of b. If b is in one line, like `func f() { x() }`,
+// the `}` is moved to its own line too, so the block is formatted like gofmt.
+func (e *stmtEditor) block(b *ast.BlockStmt) {
+	var group []ast.Stmt
+	inserted := false
+	prev := b.Lbrace + 1
+	for _, s := range b.List {
+		if _, injected := e.origins[s]; injected {
+			group = append(group, s)
+			continue
+		}
+		if len(group) > 0 {
+			e.insert(b, e.anchor(prev, s.Pos()), group)
+			group = nil
+			inserted = true
+		}
+		prev = s.End()
+	}
+
+	if len(group) > 0 {
+		e.insert(b, b.Rbrace, group)
+		return
+	}
+	if inserted && e.fset.Position(b.Lbrace).Line == e.fset.Position(b.Rbrace).Line {
+		offset := e.file.Offset(b.Rbrace)
+		trimmed := len(bytes.TrimRight(e.src[:offset], " \t"))
+		e.edits = append(e.edits, textEdit{offset: trimmed, end: offset, text: "\n" + e.lineIndent(e.file.Offset(b.Lbrace))})
+	}
+}
+
+// anchor get the position to insert before pos: the first comment on its own line between prev and pos,
+// or pos itself.
+func (e *stmtEditor) anchor(prev, pos token.Pos) token.Pos {
+	prevLine := e.fset.Position(prev).Line
+	for _, cg := range e.f.Comments {
+		if cg.Pos() >= prev && cg.End() <= pos && e.fset.Position(cg.Pos()).Line > prevLine {
+			return cg.Pos()
+		}
+	}
+
+	return pos
+}
+
+// insert add the edit which inserts stmts before pos in b.
+func (e *stmtEditor) insert(b *ast.BlockStmt, pos token.Pos, stmts []ast.Stmt) {
+	offset := e.file.Offset(pos)
+	start := bytes.LastIndexByte(e.src[:offset], '\n') + 1
+	prefix := string(e.src[start:offset])
+	base := e.lineIndent(e.file.Offset(b.Lbrace))
+
+	// pos is at the head of line, the stmts are inserted as the lines before it.
+	if strings.TrimSpace(prefix) == "" {
+		indent := prefix
+		if pos == b.Rbrace {
+			indent += e.unit
+		}
+		e.edits = append(e.edits, textEdit{offset: start, text: e.lines(stmts, indent) + "\n"})
+		return
+	}
+
+	// pos follows other code in the same line, like `func f() { x() }`, break the line before pos and
+	// replace the spaces ahead of it.
+	indent := base + e.unit
+	text := "\n" + e.lines(stmts, indent) + "\n" + indent
+	if pos == b.Rbrace {
+		text = "\n" + e.lines(stmts, indent) + "\n" + base
+	}
+	trimmed := start + len(strings.TrimRight(prefix, " \t"))
+	e.edits = append(e.edits, textEdit{offset: trimmed, end: offset, text: text})
+}
+
+// lineIndent get the indent of the line which offset is in.
+func (e *stmtEditor) lineIndent(offset int) string {
+	start := bytes.LastIndexByte(e.src[:offset], '\n') + 1
+	line := string(e.src[start:])
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// lines print stmts, every line is indented by indent, and the nested lines use the indent unit of file.
+// The lines which start inside a raw string literal are kept as they are, since they are the content
+// of the string.
+func (e *stmtEditor) lines(stmts []ast.Stmt, indent string) string {
+	var lines []string
+	for _, s := range stmts {
+		code := printStmt(s)
+		inString := rawStringLines(code)
+		for i, l := range strings.Split(code, "\n") {
+			if inString[i] {
+				lines = append(lines, l)
+				continue
+			}
+			tabs := len(l) - len(strings.TrimLeft(l, "\t"))
+			lines = append(lines, indent+strings.Repeat(e.unit, tabs)+l[tabs:])
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// rawStringLines get the index of the lines of code which start inside a raw string literal.
+func rawStringLines(code string) map[int]bool {
+	lines := make(map[int]bool)
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(code))
+	s.Init(file, []byte(code), nil, 0)
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok != token.STRING || !strings.HasPrefix(lit, "`") {
+			continue
+		}
+		// the lines after the first one of literal start inside it.
+		first := file.Line(pos) - 1
+		for i := 1; i <= strings.Count(lit, "\n"); i++ {
+			lines[first+i] = true
+		}
+	}
+
+	return lines
+}
+
+// printStmt print s like gofmt. The positions of s are not in the source file, so it is printed with an
+// empty FileSet, which keeps the layout of printer.
+func printStmt(s ast.Stmt) string {
+	var buf bytes.Buffer
+	cfg := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+	cfg.Fprint(&buf, token.NewFileSet(), s)
+
+	return buf.String()
+}
+
+// insertImports add the imports of packs to src, the pack which is imported already is skipped.
+// The new imports are put ahead of the first import declaration, which is converted to the group
+// form if it has only one import. added reports whether any pack is added.
+func insertImports(src []byte, packs []Pack) (dest []byte, added bool, err error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ImportsOnly)
+	if err != nil {
+		return nil, false, err
+	}
+
+	imported := make(map[string]bool)
+	for _, i := range f.Imports {
+		imported[importKey(i)] = true
+	}
+
+	var specs []string
+	for _, p := range packs {
+		impor, err := parserImport(p)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, spec := range impor {
+			if key := importKey(spec.(*ast.ImportSpec)); !imported[key] {
+				imported[key] = true
+				specs = append(specs, key)
+			}
+		}
+	}
+	if len(specs) == 0 {
+		return src, false, nil
+	}
+
+	sort.Strings(specs)
+	unit := indentUnit(src)
+	file := fset.File(f.Pos())
+
+	for _, d := range f.Decls {
+		gd, ok := d.(*ast.GenDecl)
+		if !ok || gd.Tok != token.IMPORT {
+			continue
+		}
+
+		if gd.Lparen.IsValid() {
+			return applyEdits(src, groupImportEdits(fset, src, gd, specs, unit)), true, nil
+		}
+
+		start, end := file.Offset(gd.Pos()), file.Offset(gd.End())
+		// the line comment of the import is kept with it.
+		if rest := src[end:]; bytes.HasPrefix(bytes.TrimLeft(rest, " \t"), []byte("//")) {
+			if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+				end += i
+			} else {
+				end = len(src)
+			}
+		}
+		all := append(specs, string(src[file.Offset(gd.Specs[0].Pos()):end]))
+		sort.Slice(all, func(i, j int) bool {
+			return importPath(all[i]) < importPath(all[j])
+		})
+		text := "import (\n" + unit + strings.Join(all, "\n"+unit) + "\n)"
+		return applyEdits(src, []textEdit{{offset: start, end: end, text: text}}), true, nil
+	}
+
+	offset := file.Offset(f.Name.End())
+	text := "\n\nimport (\n" + unit + strings.Join(specs, "\n"+unit) + "\n)"
+	return applyEdits(src, []textEdit{{offset: offset, text: text}}), true, nil
+}
+
+// groupImportEdits get the edits which insert specs into the first run of the import group gd. Every spec is
+// put before the first import with a larger path, so the run keeps sorted like gofmt does.
+func groupImportEdits(fset *token.FileSet, src []byte, gd *ast.GenDecl, specs []string, unit string) (edits []textEdit) {
+	file := fset.File(gd.Pos())
+	if len(gd.Specs) == 0 {
+		text := "\n" + unit + strings.Join(specs, "\n"+unit)
+		return []textEdit{{offset: file.Offset(gd.Lparen) + 1, text: text}}
+	}
+
+	// the first run ends at the first blank line between imports.
+	run := gd.Specs[:1]
+	for i := 1; i < len(gd.Specs); i++ {
+		if fset.Position(gd.Specs[i].Pos()).Line > fset.Position(gd.Specs[i-1].End()).Line+1 {
+			break
+		}
+		run = gd.Specs[:i+1]
+	}
+
+	for _, spec := range specs {
+		offset := -1
+		for _, r := range run {
+			if r.(*ast.ImportSpec).Path.Value > importPath(spec) {
+				offset = file.Offset(r.Pos())
+				break
+			}
+		}
+		if offset < 0 {
+			end := file.Offset(run[len(run)-1].End())
+			edits = append(edits, textEdit{offset: end, text: "\n" + unit + spec})
+			continue
+		}
+		// the spec is put at the head of the line, before the name of the import.
+		start := bytes.LastIndexByte(src[:offset], '\n') + 1
+		edits = append(edits, textEdit{offset: start, text: unit + spec + "\n"})
+	}
+
+	return edits
+}
+
+// importPath get the quoted path of the import spec s, which may have a name and a line comment.
+func importPath(s string) string {
+	i := strings.IndexAny(s, "\"`")
+	if i < 0 {
+		return s
+	}
+	j := strings.IndexByte(s[i+1:], s[i])
+	if j < 0 {
+		return s[i:]
+	}
+
+	return s[i : i+j+2]
+}
+
+// usedPacks get the packs which src refers to, like `time` of `time.Second`, so the pack of code which
+// is not inserted, e.g. the stmt whose depended variable is not in the function, is not imported and not used. The pack
+// whose name is unknown is kept, like `gopkg.in/yaml.v3`, `github.com/x/y/v2` or the blank import,
+// name it by Pack.Name to skip it when unused.
+func usedPacks(src []byte, packs []Pack) []Pack {
+	used := make(map[string]bool)
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	s.Init(fset.AddFile("", fset.Base(), len(src)), src, nil, 0)
+	prev, lit := token.ILLEGAL, ""
+	for {
+		_, tok, l := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.PERIOD && prev == token.IDENT {
+			used[lit] = true
+		}
+		prev, lit = tok, l
+	}
+
+	var result []Pack
+	for _, p := range packs {
+		name := packName(p)
+		if name == "" || used[name] {
+			result = append(result, p)
+		}
+	}
+
+	return result
+}
+
+// packName get the name which the code refers to the pack by, or empty string if it is unknown.
+func packName(p Pack) string {
+	if p.Name == "_" || p.Name == "." {
+		return ""
+	}
+	if p.Name != "" {
+		return p.Name
+	}
+
+	path, err := strconv.Unquote(strings.TrimSpace(p.Path))
+	if err != nil {
+		return ""
+	}
+	name := path[strings.LastIndexByte(path, '/')+1:]
+	if !token.IsIdentifier(name) || len(name) > 1 && name[0] == 'v' && strings.Trim(name[1:], "0123456789") == "" {
+		return ""
+	}
+
+	return name
+}
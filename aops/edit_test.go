@@ -0,0 +1,244 @@
+package aops
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAddCodeMinimalDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		stmt StmtParams
+		want string
+	}{
+		{
+			name: "keep comments and format",
+			src: "package orders\n\nimport \"fmt\"\n\n// Create\n// @trace\nfunc Create(id int) {\n\t// print the id\n\tfmt.Println(id,   // id\n\t\t\"created\")\n}\n",
+			stmt: StmtParams{
+				Stmts: []StmtParam{
+					{Kind: AddFuncWithoutDepends, Stmt: []string{`fmt.Println("enter")`}},
+					{Kind: AddDeferFuncStmt, Stmt: []string{`defer fmt.Println("exit")`}},
+				},
+			},
+			want: "package orders\n\nimport \"fmt\"\n\n// Create\n// @trace\nfunc Create(id int) {\n\tfmt.Println(\"enter\")\n\tdefer fmt.Println(\"exit\")\n\t// print the id\n\tfmt.Println(id,   // id\n\t\t\"created\")\n}\n",
+		},
+		{
+			name: "space indent",
+			src: "package orders\n\nimport \"fmt\"\n\n// Create\n// @trace\nfunc Create(id int) {\n    fmt.Println(id)\n}\n",
+			stmt: StmtParams{
+				Stmts: []StmtParam{
+					{Kind: AddDeferFuncStmt, Stmt: []string{"defer func() {\n\tfmt.Println(\"exit\")\n}()"}},
+				},
+			},
+			want: "package orders\n\nimport \"fmt\"\n\n// Create\n// @trace\nfunc Create(id int) {\n    defer func() {\n        fmt.Println(\"exit\")\n    }()\n    fmt.Println(id)\n}\n",
+		},
+		{
+			name: "one line body",
+			src: "package orders\n\nimport \"fmt\"\n\n// Create\n// @trace\nfunc Create(id int) { fmt.Println(id) }\n",
+			stmt: StmtParams{
+				Stmts: []StmtParam{
+					{Kind: AddFuncWithoutDepends, Stmt: []string{`fmt.Println("enter")`}},
+				},
+			},
+			want: "package orders\n\nimport \"fmt\"\n\n// Create\n// @trace\nfunc Create(id int) {\n\tfmt.Println(\"enter\")\n\tfmt.Println(id)\n}\n",
+		},
+		{
+			name: "empty body",
+			src: "package orders\n\nimport \"fmt\"\n\n// Create\n// @trace\nfunc Create(id int) {}\n",
+			stmt: StmtParams{
+				Stmts: []StmtParam{
+					{Kind: AddFuncWithoutDepends, Stmt: []string{`fmt.Println(id)`}},
+				},
+			},
+			want: "package orders\n\nimport \"fmt\"\n\n// Create\n// @trace\nfunc Create(id int) {\n\tfmt.Println(id)\n}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "code.go")
+			if err := os.WriteFile(file, []byte(tt.src), 0666); err != nil {
+				t.Fatal(err)
+			}
+			pkg, err := ParseDir(filepath.Dir(file), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			
+			stmt := map[string]StmtParams{"@trace": tt.stmt}
+			if _, err := AddCode(Position(pkg, map[string]struct{}{"@trace": {}}), stmt, true); err != nil {
+				t.Fatal(err)
+			}
+			got, _ := os.ReadFile(file)
+			if string(got) != tt.want {
+				t.Errorf("AddCode() got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_insertImports(t *testing.T) {
+	tests := []struct {
+		name      string
+		src       string
+		packs     []Pack
+		want      string
+		wantAdded bool
+	}{
+		{
+			name:      "group",
+			src:       "package orders\n\nimport (\n\t\"fmt\"\n\t\"os\"\n\n\t\"github.com/x/y\"\n)\n",
+			packs:     []Pack{{Path: `"log"`}, {Path: `"time"`}},
+			want:      "package orders\n\nimport (\n\t\"fmt\"\n\t\"log\"\n\t\"os\"\n\t\"time\"\n\n\t\"github.com/x/y\"\n)\n",
+			wantAdded: true,
+		},
+		{
+			name:      "single",
+			src:       "package orders\n\nimport \"fmt\" // print\n",
+			packs:     []Pack{{Name: "l", Path: `"log"`}},
+			want:      "package orders\n\nimport (\n\t\"fmt\" // print\n\tl \"log\"\n)\n",
+			wantAdded: true,
+		},
+		{
+			name:      "none",
+			src:       "package orders\n\nfunc Create() {}\n",
+			packs:     []Pack{{Path: `"log"`}},
+			want:      "package orders\n\nimport (\n\t\"log\"\n)\n\nfunc Create() {}\n",
+			wantAdded: true,
+		},
+		{
+			name:  "imported",
+			src:   "package orders\n\nimport \"log\"\n",
+			packs: []Pack{{Path: `"log"`}},
+			want:  "package orders\n\nimport \"log\"\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, added, err := insertImports([]byte(tt.src), tt.packs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want || added != tt.wantAdded {
+				t.Errorf("insertImports() got:\n%s\n%v, want:\n%s\n%v", got, added, tt.want, tt.wantAdded)
+			}
+		})
+	}
+}
+
+func Test_usedPacks(t *testing.T) {
+	src := []byte("package orders\n\nfunc Run() {\n\tlog.Println(time.Now(), x.y)\n}\n")
+	packs := []Pack{
+		{Path: `"log"`},
+		{Path: `"time"`},
+		{Path: `"context"`},
+		{Name: "x", Path: `"github.com/x/z"`},
+		{Name: "y", Path: `"github.com/x/y"`},
+		{Path: `"gopkg.in/yaml.v3"`},
+		{Path: `"github.com/x/y/v2"`},
+		{Name: "_", Path: `"embed"`},
+	}
+	want := []Pack{
+		{Path: `"log"`},
+		{Path: `"time"`},
+		{Name: "x", Path: `"github.com/x/z"`},
+		{Path: `"gopkg.in/yaml.v3"`},
+		{Path: `"github.com/x/y/v2"`},
+		{Name: "_", Path: `"embed"`},
+	}
+	if got := usedPacks(src, packs); !reflect.DeepEqual(got, want) {
+		t.Errorf("usedPacks() = %v, want %v", got, want)
+	}
+}
+
+func TestAddCodeUnboundType(t *testing.T) {
+	src := "package orders\n\nimport \"fmt\"\n\n// Run\n// @deadline\nfunc Run() {\n\tfmt.Println(\"run\")\n}\n"
+	file := filepath.Join(t.TempDir(), "code.go")
+	if err := os.WriteFile(file, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := ParseDir(filepath.Dir(file), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no variable has the type, the code can not be woven.
+	stmt := map[string]StmtParams{"@deadline": {
+		Stmts: []StmtParam{
+			{Kind: AddFuncWithVarStmt, Stmt: []string{`fmt.Println(__varName__.Deadline(), time.Now())`}, DependType: "context.Context"},
+		},
+		Packs: []Pack{{Path: `"time"`}},
+	}}
+	_, err = AddCode(Position(pkg, map[string]struct{}{"@deadline": {}}), stmt, true)
+	want := file + ":7: middleware @deadline: Stmt[0]: no variable of type context.Context in Run"
+	if err == nil || err.Error() != want {
+		t.Errorf("AddCode() error = %v, want %v", err, want)
+	}
+	if got, _ := os.ReadFile(file); string(got) != src {
+		t.Errorf("AddCode() should not write the file, got:\n%s", got)
+	}
+}
+
+func TestAddImportDryRun(t *testing.T) {
+	src := "package orders\n\n// Run\n// @timer\nfunc Run() {\n}\n"
+	file := filepath.Join(t.TempDir(), "code.go")
+	if err := os.WriteFile(file, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := ParseDir(filepath.Dir(file), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stmt := map[string]StmtParams{"@timer": {
+		Stmts: []StmtParam{{Kind: AddDeferFuncStmt, Stmt: []string{`defer log.Println(time.Now())`}}},
+		Packs: []Pack{{Path: `"log"`}, {Path: `"time"`}},
+	}}
+	pkgs := Position(pkg, map[string]struct{}{"@timer": {}})
+	got := captureStdout(t, func() {
+		modify, err := AddCode(pkgs, stmt, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = AddImport(pkgs, stmt, modify, false); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	want := "package orders\n\nimport (\n\t\"log\"\n\t\"time\"\n)\n\n// Run\n// @timer\nfunc Run() {\n\tdefer log.Println(time.Now())\n}\n\n"
+	if got != want {
+		t.Errorf("AddCode() and AddImport() print:\n%s\nwant:\n%s", got, want)
+	}
+	if data, _ := os.ReadFile(file); string(data) != src {
+		t.Errorf("AddCode() writes the file when replace is false:\n%s", data)
+	}
+}
+
+func TestAddCodeContextImport(t *testing.T) {
+	src := "package orders\n\n// Run\n// @trace\nfunc Run() {\n}\n"
+	file := filepath.Join(t.TempDir(), "code.go")
+	if err := os.WriteFile(file, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := ParseDir(filepath.Dir(file), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// `context` of `context.Background()` is not declared by the aspect.
+	stmt := map[string]StmtParams{"@trace": {
+		Stmts: []StmtParam{{Kind: AddFuncWithoutDepends, Stmt: []string{`fmt.Println(__ctx__)`}}},
+		Packs: []Pack{{Path: `"fmt"`}},
+	}}
+	if _, err := AddCode(Position(pkg, map[string]struct{}{"@trace": {}}), stmt, true); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := os.ReadFile(file)
+	want := "package orders\n\nimport (\n\t\"context\"\n\t\"fmt\"\n)\n\n// Run\n// @trace\nfunc Run() {\n\tfmt.Println(context.Background())\n}\n"
+	if string(got) != want {
+		t.Errorf("AddCode() got:\n%s\nwant:\n%s", got, want)
+	}
+}
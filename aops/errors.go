@@ -0,0 +1,219 @@
+package aops
+
+import (
+	"fmt"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"strings"
+)
+
+// SnippetError is returned when a code snippet of middleware can not be parsed.
+//
+// ID is the middleware id. Stmt is the index of StmtParam in StmtParams.Stmts, or the index of
+// DeclParams in StmtParams.DeclStmt if Decl is true. Code is the index of snippet in the stmt.
+// Source is where the stmt is declared, it is empty if the StmtParams is not loaded from config.
+// Pos is the error position in Snippet, the line and column start from 1.
+type SnippetError struct {
+	ID      string
+	Stmt    int
+	Decl    bool
+	Code    int
+	Source  Source
+	Snippet string
+	Pos     token.Position
+	Msg     string
+}
+
+// Error output the error like that:
+//
+//	aop.toml:12: middleware @trace: Stmt[1] code[0]: 1:20: missing ',' in argument list
+//		defer fmt.Println(1
+//		                   ^
+func (e *SnippetError) Error() string {
+	var b strings.Builder
+	if e.Source.File != "" {
+		fmt.Fprintf(&b, "%s:%d: ", e.Source.File, e.Source.Line)
+	}
+
+	field := "Stmt"
+	if e.Decl {
+		field = "DeclStmt"
+	}
+	fmt.Fprintf(&b, "middleware %s: %s[%d] code[%d]: ", e.ID, field, e.Stmt, e.Code)
+	if e.Pos.IsValid() {
+		fmt.Fprintf(&b, "%d:%d: ", e.Pos.Line, e.Pos.Column)
+	}
+	b.WriteString(e.Msg)
+
+	lines := strings.Split(e.Snippet, "\n")
+	if e.Pos.IsValid() && e.Pos.Line <= len(lines) {
+		line := lines[e.Pos.Line-1]
+		// keep tabs, so the caret has the same indent as the line.
+		caret := []rune{}
+		for i, r := range line {
+			if i >= e.Pos.Column-1 {
+				break
+			}
+			if r == '\t' {
+				caret = append(caret, '\t')
+			} else {
+				caret = append(caret, ' ')
+			}
+		}
+		fmt.Fprintf(&b, "\n\t%s\n\t%s^", line, string(caret))
+	}
+
+	return b.String()
+}
+
+// newSnippetError convert the parse error of snippet to SnippetError.
+func newSnippetError(id string, stmt, code int, source Source, snippet string, err error) *SnippetError {
+	se := &SnippetError{
+		ID:      id,
+		Stmt:    stmt,
+		Code:    code,
+		Source:  source,
+		Snippet: snippet,
+		Msg:     err.Error(),
+	}
+
+	if errs, ok := err.(scanner.ErrorList); ok && len(errs) > 0 {
+		se.Pos = errs[0].Pos
+		se.Msg = errs[0].Msg
+
+		// The error at the end of wrapped snippet, like the missing '}', points to the end of snippet.
+		lines := strings.Split(snippet, "\n")
+		if se.Pos.Line > len(lines) {
+			se.Pos.Line = len(lines)
+			se.Pos.Column = len(lines[len(lines)-1]) + 1
+			se.Pos.Offset = len(snippet)
+		}
+	}
+
+	return se
+}
+
+// CheckStmt check whether every code in sp.Stmt is valid for sp.Kind.
+// AddFuncWithoutDepends and AddFuncWithoutDependsWithInject need expressions, like `fmt.Println("x")`,
+// the other kinds need statements. The code contains template actions is only checked as a template,
+// since it is not Go code until rendered with JoinPoint.
+// id is the middleware id and idx is the index of sp in StmtParams.Stmts, they are used for error message.
+// Return the first error as *SnippetError, nil if all codes are valid.
+func CheckStmt(id string, idx int, sp StmtParam) error {
+	for k, s := range sp.Stmt {
+		var err error
+		switch {
+		case isTemplate(s):
+			_, err = parseTemplate(s)
+		case sp.Kind == AddFuncWithoutDepends, sp.Kind == AddFuncWithoutDependsWithInject:
+			_, err = parser.ParseExpr(s)
+		default:
+			_, err = parserStmt(s)
+		}
+		if err != nil {
+			return newSnippetError(id, idx, k, sp.Source, s, err)
+		}
+	}
+
+	return nil
+}
+
+// CheckStmtParams check all the codes of Stmts and DeclStmt in sp, return the first error as *SnippetError.
+// `AddCode` checks every middleware before insert its code.
+func CheckStmtParams(id string, sp StmtParams) error {
+	for i, s := range sp.Stmts {
+		if err := CheckStmt(id, i, s); err != nil {
+			return err
+		}
+	}
+
+	for i, d := range sp.DeclStmt {
+		for k, s := range d.Stmt {
+			var err error
+			if isTemplate(s) {
+				_, err = parseTemplate(s)
+			} else {
+				_, err = parserStmt(s)
+			}
+			if err != nil {
+				se := newSnippetError(id, i, k, Source{}, s, err)
+				se.Decl = true
+				return se
+			}
+		}
+	}
+
+	return nil
+}
+
+// AnnotationError is returned when the arguments of AOP id in comment are invalid, like `@trace(name:)`.
+// Pos points to the mistake in source code. When the error is returned by parsing the id alone, Pos is
+// relative to the id.
+type AnnotationError struct {
+	ID  string
+	Pos token.Position
+	Msg string
+}
+
+// Error output the error like that:
+//
+//	code.go:12:17: annotation @trace: expected operand, found '}'
+func (e *AnnotationError) Error() string {
+	return fmt.Sprintf("%s: annotation %s: %s", e.Pos, e.ID, e.Msg)
+}
+
+// TypeError is a type error of the woven code, it is reported by the type check of `AddCodeWithOptions`.
+//
+// Pos is the position before weaving, since the woven file is not written: the position in the original
+// file, or the position in the snippet for the inserted code, e.g. `aop.toml:7:19` for the Stmt at line 7
+// of aop.toml, or `@trace/Stmt[1]:1:19` if the Stmt has no Source. ID is the middleware id which inserts
+// the code, it is empty if the error is not in the inserted code. Stmt is the index of StmtParam in
+// StmtParams.Stmts, or the index of DeclParams in StmtParams.DeclStmt if Decl is true, it is -1 for the
+// declarations of arguments. Source is where the stmt is declared.
+type TypeError struct {
+	ID     string
+	Stmt   int
+	Decl   bool
+	Source Source
+	Pos    token.Position
+	Msg    string
+}
+
+// Error output the error like that:
+//
+//	aop.toml:12:19: middleware @trace: Stmt[1] (aop.toml:12): undefined: span
+func (e *TypeError) Error() string {
+	if e.ID == "" {
+		return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: middleware %s: ", e.Pos, e.ID)
+	switch {
+	case e.Decl:
+		fmt.Fprintf(&b, "DeclStmt[%d]", e.Stmt)
+	case e.Stmt < 0:
+		b.WriteString("arguments")
+	default:
+		fmt.Fprintf(&b, "Stmt[%d]", e.Stmt)
+	}
+	if e.Source.File != "" {
+		fmt.Fprintf(&b, " (%s:%d)", e.Source.File, e.Source.Line)
+	}
+	fmt.Fprintf(&b, ": %s", e.Msg)
+
+	return b.String()
+}
+
+// TypeErrors are all the type errors of the woven files.
+type TypeErrors []*TypeError
+
+func (e TypeErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+
+	return strings.Join(msgs, "\n")
+}
@@ -0,0 +1,467 @@
+package aops
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// weaveGolden copy src to a temp dir and weave it with stmt, then compare the result with golden file.
+// Run `go test -update` to regenerate the golden file.
+func weaveGolden(t *testing.T, src, golden string, stmt map[string]StmtParams) {
+	t.Helper()
+	weaveGoldenWithOptions(t, src, golden, stmt, Options{})
+}
+
+// weaveGoldenWithOptions is the same as weaveGolden, but weave with opt. The temp dir in result is removed,
+// so the absolute paths of `//line` directives are the same between runs.
+func weaveGoldenWithOptions(t *testing.T, src, golden string, stmt map[string]StmtParams, opt Options) {
+	t.Helper()
+	
+	data, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	
+	dir := t.TempDir()
+	file := filepath.Join(dir, filepath.Base(src))
+	if err := os.WriteFile(file, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	
+	pkg, err := ParseDir(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	
+	ids := make(map[string]struct{})
+	for id := range stmt {
+		ids[id] = struct{}{}
+	}
+	
+	pkgs := Position(pkg, ids)
+	modify, err := AddCodeWithOptions(pkgs, stmt, true, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	
+	err = AddImport(pkgs, stmt, modify, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	
+	got, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = bytes.ReplaceAll(got, []byte(dir+string(filepath.Separator)), nil)
+	
+	if *update {
+		if err := os.WriteFile(golden, got, 0666); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	
+	if string(got) != string(want) {
+		t.Errorf("weave %s got:\n%s\nwant:\n%s", src, got, want)
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	aspect := func(id string, order int) StmtParams {
+		return StmtParams{
+			Stmts: []StmtParam{
+				{
+					Kind: AddFuncWithoutDepends,
+					Stmt: []string{`fmt.Println("` + id + ` before")`},
+				},
+				{
+					Kind: AddDeferFuncStmt,
+					Stmt: []string{`defer fmt.Println("` + id + ` after")`},
+				},
+			},
+			Order: order,
+		}
+	}
+	
+	weaveGolden(t, "../cases/middleware-order/code.go", "../cases/middleware-order/code.golden", map[string]StmtParams{
+		"@middleware-log":     aspect("@middleware-log", 0),
+		"@middleware-trace":   aspect("@middleware-trace", 0),
+		"@middleware-recover": aspect("@middleware-recover", -1),
+	})
+}
+
+func TestJoinPoint(t *testing.T) {
+	weaveGolden(t, "../cases/join-point/code.go", "../cases/join-point/code.golden", map[string]StmtParams{
+		"@trace": {
+			Stmts: []StmtParam{
+				{
+					Kind: AddFuncWithoutDepends,
+					Stmt: []string{`fmt.Println("{{.FullName}}", {{if .Args.span}}{{.Args.span}}{{else}}"{{.FuncName}}"{{end}}, {{.Line}})`},
+				},
+				{
+					Kind: AddDeferFuncStmt,
+					Stmt: []string{`defer fmt.Println("{{.Package}}"{{range .Params}}, {{.Name}}{{end}}{{range .Results}}, "{{.Type}}"{{end}})`},
+				},
+			},
+		},
+	})
+}
+
+func TestGenerics(t *testing.T) {
+	weaveGolden(t, "../cases/generic/code.go", "../cases/generic/code.golden", map[string]StmtParams{
+		"@trace": {
+			Stmts: []StmtParam{
+				{
+					Kind: AddFuncWithoutDepends,
+					Stmt: []string{`fmt.Println("{{.FullName}}"{{range .TypeParams}}, "{{.Name}} {{.Type}}"{{end}})`},
+				},
+				{
+					Kind: AddDeferFuncStmt,
+					Stmt: []string{`defer fmt.Println("{{.Receiver}}", "{{.TypeArgs}}")`},
+				},
+			},
+		},
+	})
+}
+
+func TestTypeAnnotation(t *testing.T) {
+	weaveGolden(t, "../cases/type-annotation/code.go", "../cases/type-annotation/code.golden", map[string]StmtParams{
+		"@trace": {
+			Stmts: []StmtParam{
+				{Kind: AddFuncWithoutDepends, Stmt: []string{`fmt.Println("trace", {{.Args.span}}, "{{.FullName}}")`}},
+			},
+			Params: []Param{{Name: "span", Type: "string", Default: `"default"`}},
+		},
+		"@log": {
+			Stmts: []StmtParam{
+				{Kind: AddDeferFuncStmt, Stmt: []string{`defer fmt.Println("log", "{{.FuncName}}")`}},
+			},
+			Order: 1,
+		},
+	})
+}
+
+func TestPackageAnnotation(t *testing.T) {
+	weaveGolden(t, "../cases/package-annotation/code.go", "../cases/package-annotation/code.golden", map[string]StmtParams{
+		"@trace": {
+			Stmts: []StmtParam{
+				{Kind: AddFuncWithoutDepends, Stmt: []string{`fmt.Println("trace", {{.Args.span}}, "{{.FullName}}")`}},
+			},
+			Params: []Param{{Name: "span", Type: "string", Default: `"default"`}},
+		},
+		"@log": {
+			Stmts: []StmtParam{
+				{Kind: AddDeferFuncStmt, Stmt: []string{`defer fmt.Println("log", "{{.FuncName}}")`}},
+			},
+		},
+	})
+}
+
+func TestParams(t *testing.T) {
+	weaveGolden(t, "../cases/ratelimit/code.go", "../cases/ratelimit/code.golden", map[string]StmtParams{
+		"@ratelimit": {
+			Stmts: []StmtParam{
+				{
+					Kind: AddFuncWithoutDependsWithInject,
+					Stmt: []string{`fmt.Println(qps, burst, timeout)`},
+				},
+			},
+			Params: []Param{
+				{Name: "qps", Type: "int", Required: true},
+				{Name: "burst", Type: "int", Default: "10"},
+				{Name: "timeout", Type: "time.Duration", Default: "3*time.Second"},
+			},
+		},
+	})
+}
+
+func TestArgsForEveryKind(t *testing.T) {
+	weaveGolden(t, "../cases/timer/code.go", "../cases/timer/code.golden", map[string]StmtParams{
+		"@timer": {
+			Stmts: []StmtParam{
+				{
+					Kind: AddDeferFuncStmt,
+					Stmt: []string{`defer func(start time.Time) { fmt.Println(name, {{.Args.name}}, time.Since(start)) }(time.Now())`},
+				},
+				{
+					Kind:    AddFuncWithVarStmt,
+					Stmt:    []string{`fmt.Println(name, err)`},
+					Depends: []string{"err"},
+				},
+			},
+			Params: []Param{
+				{Name: "name", Type: "string", Default: `"default"`},
+				{Name: "unused", Type: "int"},
+			},
+		},
+	})
+}
+
+func TestInjectJoinPoint(t *testing.T) {
+	weaveGolden(t, "../cases/inject-join-point/code.go", "../cases/inject-join-point/code.golden", map[string]StmtParams{
+		"@trace": {
+			Stmts: []StmtParam{
+				{
+					Kind: AddDeferFuncStmt,
+					Stmt: []string{`defer func() { fmt.Println(jp.Func, jp.Args["span"], jp.Elapsed()) }()`},
+				},
+			},
+			Packs: []Pack{{Path: `"github.com/runways/goAOP/inject"`}},
+			Params: []Param{
+				{Name: "span", Type: "string", Default: `"default"`},
+				{Name: "jp", Type: "inject.JoinPoint", Default: "@inject"},
+			},
+		},
+		"@log": {
+			Stmts: []StmtParam{
+				{
+					Kind: AddFuncWithoutDependsWithInject,
+					Stmt: []string{`fmt.Println(label.Name)`},
+				},
+			},
+			Params: []Param{
+				{Name: "label", Default: "@inject"},
+			},
+		},
+	})
+}
+
+func TestFuncArgs(t *testing.T) {
+	weaveGolden(t, "../cases/func-args/code.go", "../cases/func-args/code.golden", map[string]StmtParams{
+		"@log": {
+			Stmts: []StmtParam{
+				{
+					Kind: AddFuncWithoutDepends,
+					Stmt: []string{`fmt.Println("{{.FullName}}", __args__)`},
+				},
+			},
+		},
+	})
+}
+
+func TestFuncCtx(t *testing.T) {
+	weaveGolden(t, "../cases/ctx/code.go", "../cases/ctx/code.golden", map[string]StmtParams{
+		"@timeout": {
+			Stmts: []StmtParam{
+				{
+					Kind: AddAroundCtxStmt,
+					Stmt: []string{
+						"__ctx__, cancel := context.WithTimeout(__ctx__, time.Second)",
+						"defer cancel()",
+					},
+				},
+				{
+					Kind: AddFuncWithoutDepends,
+					Stmt: []string{`fmt.Println("{{.FullName}}", __ctx__)`},
+				},
+			},
+			Packs: []Pack{{Path: `"context"`}, {Path: `"time"`}},
+		},
+	})
+}
+
+func TestDependType(t *testing.T) {
+	weaveGolden(t, "../cases/depend-type/code.go", "../cases/depend-type/code.golden", map[string]StmtParams{
+		"@deadline": {
+			Stmts: []StmtParam{
+				{
+					Kind:       AddFuncWithVarStmt,
+					Stmt:       []string{`fmt.Println("ctx", __varName__)`},
+					DependType: "context.Context",
+				},
+			},
+		},
+		"@timeout": {
+			Stmts: []StmtParam{
+				{
+					Kind:       AddFuncWithVarStmt,
+					Stmt:       []string{`__varName__.Timeout = time.Second`},
+					DependType: "*http.Client",
+				},
+			},
+			Packs: []Pack{{Path: `"time"`}},
+		},
+		"@handler": {
+			Stmts: []StmtParam{
+				{
+					Kind:       AddReturnFuncWithVarStmt,
+					Stmt:       []string{`fmt.Println(__varName__.Method)`},
+					DependType: "*http.Request",
+				},
+			},
+		},
+	})
+}
+
+func TestHygiene(t *testing.T) {
+	weaveGolden(t, "../cases/hygiene/code.go", "../cases/hygiene/code.golden", map[string]StmtParams{
+		"@trace": {
+			Stmts: []StmtParam{
+				{
+					Kind: AddAroundCtxStmt,
+					Stmt: []string{`span := "trace:" + name`, `defer fmt.Println(span, Point{name: name})`},
+				},
+			},
+			Params: []Param{{Name: "name", Type: "string"}},
+		},
+		"@span": {
+			Stmts: []StmtParam{
+				{
+					Kind: AddAroundCtxStmt,
+					Stmt: []string{`span := "span"`, `defer fmt.Println(span)`},
+				},
+			},
+		},
+		"@audit": {
+			Stmts: []StmtParam{
+				{
+					Kind: AddFuncWithoutDependsWithInject,
+					Stmt: []string{`fmt.Println(user)`},
+				},
+			},
+			Params: []Param{{Name: "user", Type: "string"}, {Name: "level", Type: "int"}},
+		},
+		"@timeout": {
+			Stmts: []StmtParam{
+				{
+					Kind: AddAroundCtxStmt,
+					Stmt: []string{`__ctx__, cancel := context.WithTimeout(__ctx__, time.Second)`, `defer cancel()`},
+				},
+			},
+			Packs: []Pack{{Path: `"time"`}},
+		},
+	})
+}
+
+func TestRawString(t *testing.T) {
+	weaveGolden(t, "../cases/raw-string/code.go", "../cases/raw-string/code.golden", map[string]StmtParams{
+		"@banner": {
+			Stmts: []StmtParam{
+				{
+					Kind: AddFuncWithoutDepends,
+					Stmt: []string{"fmt.Println(`line1\nline2`)"},
+				},
+				{
+					Kind: AddDeferFuncStmt,
+					Stmt: []string{"defer func() {\n\tfmt.Println(`bye\n\tnow`)\n}()"},
+				},
+			},
+		},
+	})
+}
+
+func TestLineDirectives(t *testing.T) {
+	weaveGoldenWithOptions(t, "../cases/line-directive/code.go", "../cases/line-directive/code.golden", map[string]StmtParams{
+		"@trace": {
+			Stmts: []StmtParam{
+				{
+					Kind:   AddFuncWithoutDepends,
+					Stmt:   []string{`fmt.Println("enter", name)`},
+					Source: Source{File: "/etc/aop.toml", Line: 5},
+				},
+				{
+					Kind: AddDeferFuncStmt,
+					Stmt: []string{`defer fmt.Println("exit")`},
+				},
+			},
+			Params: []Param{{Name: "name", Default: `"create"`}},
+		},
+	}, Options{LineDirectives: true})
+}
+
+// captureStdout return all the data f writes to stdout.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() {
+		os.Stdout = stdout
+	}()
+	
+	out := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		out <- string(data)
+	}()
+	
+	f()
+	w.Close()
+	
+	return <-out
+}
+
+func TestDeterministicOutput(t *testing.T) {
+	stmt := map[string]StmtParams{
+		"@middleware-a": {
+			Stmts: []StmtParam{
+				{
+					Kind: AddFuncWithoutDepends,
+					Stmt: []string{`fmt.Println("@middleware-a")`},
+				},
+			},
+			Packs: []Pack{
+				{Name: "log", Path: `"github.com/sirupsen/logrus"`},
+			},
+		},
+		"@middleware-err": {
+			Stmts: []StmtParam{
+				{
+					Kind: AddDeferFuncStmt,
+					Stmt: []string{`defer fmt.Println("@middleware-err")`},
+				},
+			},
+			Packs: []Pack{
+				{Name: "f", Path: `"fmt"`},
+			},
+		},
+	}
+	
+	weave := func() string {
+		return captureStdout(t, func() {
+			pkg, err := ParseDir("../cases", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			
+			pkgs := Position(pkg, map[string]struct{}{"@middleware-a": {}, "@middleware-err": {}})
+			modify, err := AddCode(pkgs, stmt, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			
+			err = AddImport(pkgs, stmt, modify, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+	
+	want := weave()
+	if want == "" {
+		t.Fatal("weave output nothing")
+	}
+	
+	for i := 0; i < 10; i++ {
+		if got := weave(); got != want {
+			t.Fatalf("run %d output differs, got:\n%s\nwant:\n%s", i, got, want)
+		}
+	}
+}
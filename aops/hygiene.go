@@ -0,0 +1,203 @@
+package aops
+
+import (
+	"go/ast"
+	"go/scanner"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// takenNames get all the identifiers in fd, includes the parameters, the results and the code of
+// aspects woven already. The injected variable with one of these names collides with the function.
+func takenNames(fd *ast.FuncDecl) map[string]bool {
+	taken := make(map[string]bool)
+	ast.Inspect(fd, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok {
+			taken[id.Name] = true
+		}
+		return true
+	})
+
+	return taken
+}
+
+// paramNames get the names of the receiver, the parameters and the results of fd, which are the variables
+// in scope at the head of function body.
+func paramNames(fd *ast.FuncDecl) map[string]bool {
+	names := make(map[string]bool)
+	for _, fl := range []*ast.FieldList{fd.Recv, fd.Type.Params, fd.Type.Results} {
+		if fl == nil {
+			continue
+		}
+		for _, f := range fl.List {
+			for _, n := range f.Names {
+				names[n.Name] = true
+			}
+		}
+	}
+
+	return names
+}
+
+// uniqueName get the name which is not taken by adding the smallest number to name, like `span1`,
+// and mark it taken.
+func uniqueName(name string, taken map[string]bool) string {
+	result := name
+	for i := 1; taken[result]; i++ {
+		result = name + strconv.Itoa(i)
+	}
+	taken[result] = true
+
+	return result
+}
+
+// hygiene rename the variables which the aspect declares and collide with taken, so the injected code
+// can not redeclare or shadow the variables of function. The renamed variables are the arguments of
+// AOP id and the variables declared by the code of sp, like `span := trace.Start()`. The names of `:=`
+// which are in scope, the parameters, are not renamed, since it assigns them on purpose, e.g. `ctx` of
+// `ctx, span := trace.Start(ctx)`, but `span` is renamed if the body declares it. The placeholders, like
+// `__ctx__`, are the existing variables, they are never renamed.
+//
+// The references in the code of sp are renamed too. sp should be the copy returned by `renderStmtParams`,
+// since the code is replaced in place. The returned args have the new variable names.
+func hygiene(sp StmtParams, args []annotationArg, taken, params map[string]bool) []annotationArg {
+	renames := make(map[string]string)
+	rename := func(name string) {
+		if _, exist := renames[name]; !exist && taken[name] && name != "_" && !isPlaceholder(name) {
+			renames[name] = uniqueName(name, taken)
+		}
+	}
+
+	result := make([]annotationArg, len(args))
+	for i, a := range args {
+		rename(a.name)
+		a.ident = renames[a.name]
+		result[i] = a
+	}
+
+	for _, s := range sp.Stmts {
+		for _, code := range s.Stmt {
+			for _, name := range declaredNames(code, params) {
+				rename(name)
+			}
+		}
+	}
+
+	if len(renames) == 0 {
+		return result
+	}
+	for _, s := range sp.Stmts {
+		for k := range s.Stmt {
+			s.Stmt[k] = renameIdents(s.Stmt[k], renames)
+		}
+	}
+	for _, d := range sp.DeclStmt {
+		for k := range d.Stmt {
+			d.Stmt[k] = renameIdents(d.Stmt[k], renames)
+		}
+	}
+
+	return result
+}
+
+// declaredNames get the variables declared by code, which is `var x T` or `x := v`. The names of `:=`
+// in params and the placeholders are assigned, not declared.
+func declaredNames(code string, params map[string]bool) (names []string) {
+	stmt, err := parserStmt(code)
+	if err != nil {
+		return nil
+	}
+
+	switch t := stmt.(type) {
+	case *ast.DeclStmt:
+		if gd, ok := t.Decl.(*ast.GenDecl); ok && gd.Tok == token.VAR {
+			for _, spec := range gd.Specs {
+				for _, n := range spec.(*ast.ValueSpec).Names {
+					names = append(names, n.Name)
+				}
+			}
+		}
+	case *ast.AssignStmt:
+		if t.Tok != token.DEFINE {
+			return nil
+		}
+		for _, l := range t.Lhs {
+			id, ok := l.(*ast.Ident)
+			if !ok {
+				return nil
+			}
+			if id.Name == "_" || params[id.Name] || isPlaceholder(id.Name) {
+				continue
+			}
+			names = append(names, id.Name)
+		}
+	}
+
+	return
+}
+
+// isPlaceholder report whether name is a placeholder, like `__ctx__` and `__varName__`, which expands to
+// an existing variable.
+func isPlaceholder(name string) bool {
+	return len(name) > 4 && strings.HasPrefix(name, "__") && strings.HasSuffix(name, "__")
+}
+
+// renameIdents replace the identifiers of code by renames. The selectors, like `name` in `x.name`, and
+// the keys of composite literal, like `name` in `T{name: 1}`, are not identifiers of variables.
+func renameIdents(code string, renames map[string]string) string {
+	src := []byte(code)
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	s.Init(file, src, nil, 0)
+
+	type ident struct {
+		offset int
+		lit    string
+	}
+	var (
+		buf      strings.Builder
+		last     int
+		prev     = token.ILLEGAL
+		pending  *ident
+		brackets []token.Token
+	)
+	flush := func(key bool) {
+		if pending == nil {
+			return
+		}
+		if to, exist := renames[pending.lit]; exist && !key {
+			buf.WriteString(code[last:pending.offset])
+			buf.WriteString(to)
+			last = pending.offset + len(pending.lit)
+		}
+		pending = nil
+	}
+
+	for {
+		pos, tok, lit := s.Scan()
+		inBrace := len(brackets) > 0 && brackets[len(brackets)-1] == token.LBRACE
+		flush(tok == token.COLON && inBrace)
+		if tok == token.EOF {
+			break
+		}
+
+		switch tok {
+		case token.IDENT:
+			if prev != token.PERIOD {
+				pending = &ident{offset: file.Offset(pos), lit: lit}
+			}
+		case token.LBRACE, token.LPAREN, token.LBRACK:
+			brackets = append(brackets, tok)
+		case token.RBRACE, token.RPAREN, token.RBRACK:
+			if len(brackets) > 0 {
+				brackets = brackets[:len(brackets)-1]
+			}
+		}
+		prev = tok
+	}
+	buf.WriteString(code[last:])
+
+	return buf.String()
+}
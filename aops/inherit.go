@@ -0,0 +1,219 @@
+package aops
+
+import (
+	"go/ast"
+	"go/token"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	// directivePrefix starts a goAOP directive comment, the AOP ids in a directive are not annotations.
+	directivePrefix = "//goaop:"
+	// filterDirective limits the methods which inherit the AOP ids of type, like
+	// `//goaop:filter exported name=Get*`.
+	filterDirective = directivePrefix + "filter"
+	// skipDirective opts a method out of the inherited AOP ids, like `//goaop:skip` or `//goaop:skip @trace`.
+	skipDirective = directivePrefix + "skip"
+	// packageDirective annotates the package with AOP ids, like `//goaop:package @trace`.
+	packageDirective = directivePrefix + "package"
+)
+
+// inheritance is the AOP ids annotated on a type or package, which apply to the methods of type, or the
+// functions and methods of package.
+// ids are the origin ids with arguments, like `@trace(span:"orders")`.
+// exported and names are the filters of methods: only the exported methods, and the methods whose
+// name matches any of the patterns of `path.Match`. A bad pattern matches nothing.
+type inheritance struct {
+	ids      []string
+	exported bool
+	names    []string
+}
+
+// parseInheritance get the AOP ids and filters from doc, or nil if doc has no valid AOP id.
+// The arguments of directive are AOP ids too if directive is not empty.
+func parseInheritance(doc *ast.CommentGroup, ids map[string]struct{}, directive string) *inheritance {
+	if doc == nil {
+		return nil
+	}
+
+	in := &inheritance{}
+	for _, c := range doc.List {
+		if args, ok := directiveArgs(c.Text, filterDirective); ok {
+			for _, a := range args {
+				switch {
+				case a == "exported":
+					in.exported = true
+				case strings.HasPrefix(a, "name="):
+					in.names = append(in.names, strings.TrimPrefix(a, "name="))
+				}
+			}
+			continue
+		}
+		var found []string
+		if args, ok := directiveArgs(c.Text, directive); ok && directive != "" {
+			found = args
+		} else if !strings.HasPrefix(c.Text, directivePrefix) {
+			found = extractIdFromComment(c.Text)
+		}
+
+		for _, id := range found {
+			if _, exist := ids[extractFuncName(id)]; exist {
+				in.ids = append(in.ids, id)
+			}
+		}
+	}
+	if len(in.ids) == 0 {
+		return nil
+	}
+
+	return in
+}
+
+// match reports whether the function name passes the filters of in.
+func (in *inheritance) match(name string) bool {
+	if in == nil || (in.exported && !ast.IsExported(name)) {
+		return false
+	}
+	if len(in.names) == 0 {
+		return true
+	}
+
+	for _, p := range in.names {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// directiveArgs get the arguments of directive from the comment text, ok reports whether text is the directive.
+func directiveArgs(text, directive string) (args []string, ok bool) {
+	if text != directive && !strings.HasPrefix(text, directive+" ") {
+		return nil, false
+	}
+
+	return strings.Fields(text[len(directive):]), true
+}
+
+// typeInheritances collect the AOP ids annotated on the types of pack, key is the type name.
+// The doc of a type is the comment above its name, or above `type` if the declaration has one type.
+func typeInheritances(pack *ast.Package, ids map[string]struct{}) map[string]*inheritance {
+	result := make(map[string]*inheritance)
+	for name, f := range pack.Files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				doc := ts.Doc
+				if doc == nil && !gd.Lparen.IsValid() {
+					doc = gd.Doc
+				}
+				if in := parseInheritance(doc, ids, ""); in != nil {
+					result[ts.Name.Name] = in
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+// packageInheritances collect the AOP ids annotated on pack, from the package doc and the `//goaop:package`
+// directives, the `//goaop:filter` in the same comment group limits the ids. They are sorted by file name.
+func packageInheritances(pack *ast.Package, ids map[string]struct{}) (result []*inheritance) {
+	names := make([]string, 0, len(pack.Files))
+	for name := range pack.Files {
+		if !strings.HasSuffix(name, "_test.go") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := pack.Files[name]
+		for _, cg := range f.Comments {
+			// out of the package doc, only the directives count, since the group may be the doc of a function.
+			if cg != f.Doc {
+				cg = directiveGroup(cg)
+			}
+			if in := parseInheritance(cg, ids, packageDirective); in != nil {
+				result = append(result, in)
+			}
+		}
+	}
+
+	return
+}
+
+// directiveGroup get the goAOP directives of cg, or nil if cg has no `//goaop:package`.
+func directiveGroup(cg *ast.CommentGroup) *ast.CommentGroup {
+	var list []*ast.Comment
+	declared := false
+	for _, c := range cg.List {
+		if strings.HasPrefix(c.Text, directivePrefix) {
+			list = append(list, c)
+		}
+		if _, ok := directiveArgs(c.Text, packageDirective); ok {
+			declared = true
+		}
+	}
+	if !declared {
+		return nil
+	}
+
+	return &ast.CommentGroup{List: list}
+}
+
+// inheritedIds get the origin ids which fd inherits from ins, ins are from the outermost to the innermost,
+// e.g. the package and the receiver type. The same id of a later one replaces the earlier one, so the
+// arguments of type override the ones of package. own are the AOP ids annotated on fd, they override
+// the inherited ones. The ids skipped by `//goaop:skip` in the doc of fd are removed, the directive
+// without id skips all.
+func inheritedIds(fd *ast.FuncDecl, own []string, ins ...*inheritance) (result []string) {
+	skipped := make(map[string]bool)
+	for _, id := range own {
+		skipped[id] = true
+	}
+	if fd.Doc != nil {
+		for _, c := range fd.Doc.List {
+			args, ok := directiveArgs(c.Text, skipDirective)
+			if ok && len(args) == 0 {
+				return nil
+			}
+			for _, a := range args {
+				skipped[a] = true
+			}
+		}
+	}
+
+	index := make(map[string]int)
+	for _, in := range ins {
+		if !in.match(fd.Name.Name) {
+			continue
+		}
+		for _, id := range in.ids {
+			name := extractFuncName(id)
+			if skipped[name] {
+				continue
+			}
+			if i, exist := index[name]; exist {
+				result[i] = id
+				continue
+			}
+			index[name] = len(result)
+			result = append(result, id)
+		}
+	}
+
+	return
+}
@@ -1,13 +1,11 @@
 package aops
 
 import (
-	"bytes"
 	"fmt"
 	"go/ast"
-	"go/format"
 	"go/parser"
-	"go/printer"
 	"go/token"
+	"go/types"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -80,12 +78,34 @@ func ParseDir(dir string, filter func(info fs.FileInfo) bool) (map[string]*ast.P
 // Pkgs should generate by `parser.ParseDir` and `id` is the AOP middleware name, e.g. @trace.
 //
 // This function will ignore *_test.go. It will return a map(map[string][]string), key is file
-// name, value is a function name array.
+// name, value is a function name array. The functions of every file are sorted by their declaration
+// position, and `AddCode`, `AddImport` process the files by name order. So repeated runs on the
+// same input produce the same output.
+//
+// The AOP ids annotated on the package apply to all its functions and methods, the ids annotated on
+// a type apply to its methods in the same package, they are outer than the ids of method. More detail
+// please reference `inheritedIds`.
 func Position(pkgs map[string]*ast.Package, ids map[string]struct{}) map[string][]fun {
 	result := make(map[string][]fun)
 	
-	for _, pack := range pkgs {
-		for name, f := range pack.Files {
+	packNames := make([]string, 0, len(pkgs))
+	for packName := range pkgs {
+		packNames = append(packNames, packName)
+	}
+	sort.Strings(packNames)
+	
+	for _, packName := range packNames {
+		pack := pkgs[packName]
+		names := make([]string, 0, len(pack.Files))
+		for name := range pack.Files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		
+		packs := packageInheritances(pack, ids)
+		types := typeInheritances(pack, ids)
+		for _, name := range names {
+			f := pack.Files[name]
 			if strings.HasSuffix(name, "_test.go") {
 				continue
 			}
@@ -93,50 +113,41 @@ func Position(pkgs map[string]*ast.Package, ids map[string]struct{}) map[string]
 			for _, funDecl := range f.Decls {
 				switch t := funDecl.(type) {
 				case *ast.FuncDecl:
+					var owns []fun
+					var own []string
 					if t.Doc != nil {
 						for _, c := range t.Doc.List {
+							// the ids in directives are not annotations, like `//goaop:skip @trace`
+							if strings.HasPrefix(c.Text, directivePrefix) {
+								continue
+							}
 							// get all valid AOP ids from comment
 							_ids := extractIdFromComment(c.Text)
 							
 							validId := getIntersection(_ids, ids)
 							if len(validId) > 0 {
-								if t.Recv == nil ||
-									len(t.Recv.List) == 0 {
-									functions = append(functions, fun{
-										originIds: _ids,
-										owner:     noReceiver,
-										name:      t.Name.String(),
-										aopIds:    validId,
-									})
-								} else {
-									switch owner := t.Recv.List[0].Type.(type) {
-									case *ast.Ident:
-										functions = append(functions, fun{
-											originIds: _ids,
-											owner:     owner.Name,
-											name:      t.Name.String(),
-											aopIds:    validId,
-										})
-									case *ast.StarExpr:
-										functions = append(functions, fun{
-											originIds: _ids,
-											owner:     owner.X.(*ast.Ident).Name,
-											name:      t.Name.String(),
-											aopIds:    validId,
-										})
-									}
-									//owner := t.Recv.List[0].Type.(*ast.Ident)
-									//functions = append(functions, fun{
-									//	originIds: _ids,
-									//	owner:     owner.Name,
-									//	name:      t.Name.String(),
-									//	aopIds:    validId,
-									//})
-								}
+								owns = append(owns, fun{
+									originIds: _ids,
+									owner:     receiverName(t),
+									name:      t.Name.String(),
+									aopIds:    validId,
+								})
+								own = append(own, validId...)
 							}
 							
 						}
 					}
+					
+					// the ids of package and receiver type are outer than the ids of method.
+					if inherited := inheritedIds(t, own, append(packs, types[receiverName(t)])...); len(inherited) > 0 {
+						functions = append(functions, fun{
+							originIds: inherited,
+							owner:     receiverName(t),
+							name:      t.Name.String(),
+							aopIds:    getIntersection(inherited, ids),
+						})
+					}
+					functions = append(functions, owns...)
 				}
 			}
 			if len(functions) > 0 {
@@ -156,60 +167,29 @@ func Position(pkgs map[string]*ast.Package, ids map[string]struct{}) map[string]
 // modify is generated by AddCode. It save the files that have modified.
 //
 // If replace origin file, then set replace true, otherwise, set false.
+//
+// The imports of aspects are added by `AddCode` already, so AddImport keeps the woven files as they are.
+// It only adds the imports which the files on disk use but miss.
 func AddImport(pkgs map[string][]fun, stmt map[string]StmtParams, modify map[string][]string, replace bool) error {
-	for name := range pkgs {
+	for _, name := range sortedFiles(pkgs) {
 		aopIds, exist := modify[name]
 		if !exist {
 			continue
 		}
 		
-		fset := token.NewFileSet()
-		f, err := parser.ParseFile(fset, name, nil, parser.ParseComments)
+		src, err := os.ReadFile(name)
 		if err != nil {
 			return err
 		}
 		
-		decls := make([]ast.Decl, 0, len(f.Decls))
-		for _, decl := range f.Decls {
-			switch t := decl.(type) {
-			case *ast.GenDecl:
-				var stats []ast.Spec
-				_, ok := t.Specs[0].(*ast.ImportSpec)
-				if !ok {
-					decls = append(decls, t)
-					continue
-				}
-				
-				for _, i := range aopIds {
-					for _, p := range stmt[i].Packs {
-						impor, err := parserImport(p)
-						if err != nil {
-							return err
-						}
-						stats = append(stats, impor...)
-					}
-				}
-				
-				stats = append(stats, t.Specs...)
-				t.Specs = stats
-				decls = append(decls, t)
-			default:
-				decls = append(decls, t)
-			}
-		}
-		f.Decls = decls
-		
-		cfg := printer.Config{
-			Mode: printer.UseSpaces,
-		}
-		var buf bytes.Buffer
-		
-		cfg.Fprint(&buf, fset, f)
-		
-		dest, err := format.Source(buf.Bytes())
+		dest, added, err := insertImports(src, usedPacks(src, aopPacks(stmt, aopIds)))
 		if err != nil {
 			return err
 		}
+		// the imports are added by `AddCodeWithOptions` already, keep the file as it is.
+		if !added {
+			continue
+		}
 		
 		if replace {
 			os.WriteFile(name, dest, 0777)
@@ -220,6 +200,15 @@ func AddImport(pkgs map[string][]fun, stmt map[string]StmtParams, modify map[str
 	return nil
 }
 
+// aopPacks get the packs of all the AOP ids.
+func aopPacks(stmt map[string]StmtParams, ids []string) (packs []Pack) {
+	for _, i := range ids {
+		packs = append(packs, stmt[i].Packs...)
+	}
+	
+	return
+}
+
 // AddCode Insert AOP code to source code files.
 // `pkgs` is map that save file name and function names.
 // `pkgs` is generated by `position` function.
@@ -231,143 +220,183 @@ func AddImport(pkgs map[string][]fun, stmt map[string]StmtParams, modify map[str
 // Stmts save different OperationKind stmts. More detail info please reference StmtParam usage in types.go.
 //
 // Replace used to indicate replace source file or not. If replace == true, it replaces at the end.
-// Otherwise, it will not.
+// Otherwise, it will not, and prints the woven source instead. The imports of aspects which the woven
+// code uses are added in both cases.
 func AddCode(pkgs map[string][]fun, stmt map[string]StmtParams, replace bool) (map[string][]string, error) {
-	modify := make(map[string][]string)
-	for name, funs := range pkgs {
-		
-		fset := token.NewFileSet()
-		f, err := parser.ParseFile(fset, name, nil, parser.ParseComments)
+	return AddCodeWithOptions(pkgs, stmt, replace, Options{})
+}
+
+// Options are the optional behaviors of `AddCodeWithOptions`.
+//
+// TypeCheck type-checks every modified package with `go/types` after weaving. The errors are returned
+// as TypeErrors, every error is attributed to the middleware id and Stmt which inserts the code, and the
+// files with errors are not written.
+//
+// LineDirectives emits `//line` directives around the inserted code, so the compiler, stack traces and
+// coverage attribute the original code to its original position, and the inserted code to the Source of
+// its Stmt. More detail please reference `addLineDirectives`.
+type Options struct {
+	TypeCheck      bool
+	LineDirectives bool
+}
+
+// AddCodeWithOptions is the same as `AddCode`, but with the optional behaviors of opt.
+// The files which are not written are not in the result, so `AddImport` skips them.
+func AddCodeWithOptions(pkgs map[string][]fun, stmt map[string]StmtParams, replace bool, opt Options) (map[string][]string, error) {
+	var files []*wovenFile
+	for _, name := range sortedFiles(pkgs) {
+		wf, err := weaveFile(name, pkgs[name], stmt)
 		if err != nil {
 			return nil, err
 		}
-		
-		fm := make(map[string][]fun)
-		var addId []string
-		
-		for _, n := range funs {
-			ns, exist := fm[fmt.Sprintf("%s-%s", n.name, n.owner)]
-			if exist {
-				ns = append(ns, n)
-				fm[fmt.Sprintf("%s-%s", n.name, n.owner)] = ns
-			} else {
-				fm[fmt.Sprintf("%s-%s", n.name, n.owner)] = []fun{n}
-			}
-			
-		}
-		
-		decls := make([]ast.Decl, 0, len(f.Decls))
-		for _, decl := range f.Decls {
-			switch t := decl.(type) {
-			case *ast.FuncDecl:
-				if _fn, exist := fm[fullId(t)]; exist {
-					for _, fn := range _fn {
-						if isEqual(t, fn) {
-							var stats []ast.Stmt
-							for idx, id := range fn.aopIds {
-								
-								ij := injectDetail{
-									owner: fn.owner,
-									name:  fn.name,
-								}
-								
-								funcVarStmt, exprs, err := ij.getAddFuncWithoutDependsStmt(stmt[id], fn.originIds[idx])
-								if err != nil {
-									return nil, err
-								}
-								
-								stmts, err := ij.getDeferFuncStmt(stmt[id])
-								if err != nil {
-									return nil, err
-								}
-								
-								funcs, depends, funcDepends, stmtStr, err := ij.getFuncStmt(stmt[id])
-								if err != nil {
-									return nil, err
-								}
-								
-								rets, err := ij.getReturnFuncWithoutVarStmt(stmt[id])
-								if err != nil {
-									return nil, err
-								}
-								
-								retVars, retDepends, err := ij.getReturnFuncWithVarStmt(stmt[id])
-								if err != nil {
-									return nil, err
-								}
-								
-								err = addFuncWithoutDependsOperator(t, exprs)
-								if err != nil {
-									return nil, err
-								}
-								
-								err = addStmtAsFuncWithoutVarOperator(t, funcVarStmt)
-								if err != nil {
-									return nil, err
-								}
-								
-								err = addDeferWithoutVarOperator(t, stmts)
-								if err != nil {
-									return nil, err
-								}
-								err = addStmtAsFuncWithVarOperator(t, funcs, depends, funcDepends, stmtStr)
-								if err != nil {
-									return nil, err
-								}
-								err = addStmtAsReturnOperator(t, rets)
-								if err != nil {
-									return nil, err
-								}
-								
-								err = addReturnWithBindVarOperator(t, retVars, retDepends)
-								if err != nil {
-									return nil, err
-								}
-								
-								err = addStmtBindVarOperator(t, stmt[id].DeclStmt)
-								if err != nil {
-									return nil, err
-								}
-								
-								stats = append(stats, t.Body.List...)
-								if len(stats) > 0 {
-									addId = append(addId, id)
-								}
-							}
-							
-							t.Body.List = stats
-						}
-					}
-					
-				}
-				decls = append(decls, t)
-			default:
-				decls = append(decls, t)
-			}
-		}
-		f.Decls = decls
-		
-		cfg := printer.Config{
-			Mode: printer.UseSpaces,
+		files = append(files, wf)
+	}
+	
+	// the imports are added to the woven source, so the printed source has them too when replace is false.
+	for _, wf := range files {
+		src, _, err := insertImports(wf.src, usedPacks(wf.src, wf.packs))
+		if err != nil {
+			return nil, err
 		}
-		var buf bytes.Buffer
-		
-		cfg.Fprint(&buf, fset, f)
-		
-		dest, err := format.Source(buf.Bytes())
+		wf.src = src
+	}
+	
+	var errs TypeErrors
+	if opt.TypeCheck {
+		var err error
+		errs, err = typeCheck(files)
 		if err != nil {
 			return nil, err
 		}
+	}
+	
+	if opt.LineDirectives {
+		for _, wf := range files {
+			src, err := addLineDirectives(wf)
+			if err != nil {
+				return nil, err
+			}
+			wf.src = src
+		}
+	}
+	
+	modify := make(map[string][]string)
+	for _, wf := range files {
+		if wf.failed {
+			continue
+		}
 		
 		if replace {
-			os.WriteFile(name, dest, 0777)
+			os.WriteFile(wf.name, wf.src, 0777)
 		} else {
-			fmt.Println(string(dest))
+			fmt.Println(string(wf.src))
 			
 		}
 		
-		modify[name] = addId
+		modify[wf.name] = wf.ids
+	}
+	
+	if len(errs) > 0 {
+		return removeDuplicate(modify), errs
 	}
 	
 	return removeDuplicate(modify), nil
 }
+
+// wovenFile is a file which the aspects are woven into. src is the original source with the inserted code.
+// origins save the middleware id and Stmt of the stmts inserted in f.
+// packs are the imports of the woven aspects, includes the ones which the placeholders use.
+// failed marks the file can not pass the type check.
+type wovenFile struct {
+	name    string
+	fset    *token.FileSet
+	f       *ast.File
+	ids     []string
+	origins map[ast.Stmt]stmtOrigin
+	src     []byte
+	packs   []Pack
+	failed  bool
+}
+
+// weaveFile weave the aspects of funs into the file name.
+func weaveFile(name string, funs []fun, stmt map[string]StmtParams) (*wovenFile, error) {
+	src, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, name, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	
+	wf := &wovenFile{name: name, fset: fset, f: f, origins: make(map[ast.Stmt]stmtOrigin)}
+	
+	fm := make(map[string][]fun)
+	importPath := getImportPath(name)
+	
+	// the types of variables are resolved before weaving, only if an aspect binds the variable by type.
+	var info *types.Info
+	for _, n := range funs {
+		for _, id := range n.aopIds {
+			if info == nil && hasDependType(stmt[id]) {
+				info = typeInfo(fset, name, f)
+			}
+		}
+	}
+	
+	for _, n := range funs {
+		ns, exist := fm[fmt.Sprintf("%s-%s", n.name, n.owner)]
+		if exist {
+			ns = append(ns, n)
+			fm[fmt.Sprintf("%s-%s", n.name, n.owner)] = ns
+		} else {
+			fm[fmt.Sprintf("%s-%s", n.name, n.owner)] = []fun{n}
+		}
+		
+	}
+	
+	decls := make([]ast.Decl, 0, len(f.Decls))
+	for _, decl := range f.Decls {
+		switch t := decl.(type) {
+		case *ast.FuncDecl:
+			if _fn, exist := fm[fullId(t)]; exist {
+				aspects := sortAspects(t, _fn, stmt)
+				for i := range aspects {
+					aspects[i].pos = commentPosition(fset, t, aspects[i].originId)
+				}
+				jp := newJoinPoint(fset, f, importPath, t)
+				for _, a := range aspects {
+					jp.IDs = append(jp.IDs, a.id)
+				}
+				// Weave from the innermost aspect to the outermost one. Every operator puts
+				// its code ahead of the code inserted before, so the outermost aspect's code
+				// lands first.
+				for i := len(aspects) - 1; i >= 0; i-- {
+					sp := stmt[aspects[i].id]
+					wf.packs = append(wf.packs, sp.Packs...)
+					wf.packs = append(wf.packs, placeholderPacks(t, sp)...)
+					err = weaveAspect(t, aspects[i], sp, jp, info, wf.origins)
+					if err != nil {
+						return nil, err
+					}
+					
+					wf.ids = append(wf.ids, aspects[i].id)
+				}
+			}
+			decls = append(decls, t)
+		default:
+			decls = append(decls, t)
+		}
+	}
+	f.Decls = decls
+	
+	// only the inserted code is printed, every other byte of the file is kept.
+	wf.src = applyEdits(src, insertionEdits(fset, f, src, wf.origins))
+	if _, err := parser.ParseFile(token.NewFileSet(), name, wf.src, parser.ParseComments); err != nil {
+		return nil, err
+	}
+	
+	return wf, nil
+}
@@ -16,45 +16,31 @@ func Test_parserStmt(t *testing.T) {
 	tests := []struct {
 		name    string
 		args    args
-		want    ast.Stmt
+		want    string
 		wantErr bool
 	}{
 		{
-			name: "valid stmt",
-			args: struct{ stmt string }{stmt: `var b = 11`},
-			want: &ast.DeclStmt{Decl: &ast.GenDecl{
-				Doc:    nil,
-				TokPos: 8,
-				Tok:    token.VAR,
-				Lparen: token.NoPos,
-				Specs: []ast.Spec{
-					&ast.ValueSpec{
-						Doc: nil,
-						Names: []*ast.Ident{&ast.Ident{
-							NamePos: token.Pos(12),
-							Name:    "b",
-							Obj:     nil,
-						}},
-						Type: nil,
-						Values: []ast.Expr{
-							&ast.BasicLit{
-								ValuePos: token.Pos(16),
-								Kind:     5,
-								Value:    "11",
-							},
-						},
-						Comment: nil,
-					},
-				},
-				Rparen: token.NoPos,
-			}},
+			name:    "valid stmt",
+			args:    struct{ stmt string }{stmt: `var b = 11`},
+			want:    `var b = 11`,
+			wantErr: false,
+		},
+		{
+			name:    "comment at the end",
+			args:    struct{ stmt string }{stmt: `b := 11 // eleven`},
+			want:    `b := 11`,
+			wantErr: false,
+		},
+		{
+			name:    "expr",
+			args:    struct{ stmt string }{stmt: `fmt.Println(b)`},
+			want:    `fmt.Println(b)`,
 			wantErr: false,
 		},
 		{
 			name:    "inValid stmt",
 			args:    struct{ stmt string }{stmt: `var b=`},
 			wantErr: true,
-			want:    nil,
 		},
 	}
 	for _, tt := range tests {
@@ -64,8 +50,11 @@ func Test_parserStmt(t *testing.T) {
 				t.Errorf("parserStmt() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("parserStmt() got = %v, want %v", got, tt.want)
+			if err != nil {
+				return
+			}
+			if printed := printStmt(got); printed != tt.want {
+				t.Errorf("parserStmt() got = %v, want %v", printed, tt.want)
 			}
 		})
 	}
@@ -98,6 +87,9 @@ func Test_Position(t *testing.T) {
 			want: map[string][]fun{
 				"../unitTests/test.go": []fun{
 					{
+						originIds: []string{
+							"@middleware-a",
+						},
 						owner: "FirstStruct",
 						name:  "InvokeFirstFunction",
 						aopIds: []string{
@@ -105,6 +97,9 @@ func Test_Position(t *testing.T) {
 						},
 					},
 					{
+						originIds: []string{
+							"@middleware-a",
+						},
 						owner: "",
 						name:  "InvokeFirstFunction",
 						aopIds: []string{
@@ -487,6 +482,21 @@ func Test_extractIdFromComment(t *testing.T) {
 `},
 			want: nil,
 		},
+		{
+			name: "id with arguments",
+			args: struct{ comment string }{comment: `// a function comment
+// @middleware-a(path:"/a, b", msg:"a:b)", f:fmt.Sprint("(", 1)) @middleware-b
+// email@example.com
+`},
+			want: []string{`@middleware-a(path:"/a, b", msg:"a:b)", f:fmt.Sprint("(", 1))`, "@middleware-b"},
+		},
+		{
+			name: "arguments not closed",
+			args: struct{ comment string }{comment: `// @middleware-a(path:"/a"
+// @middleware-b
+`},
+			want: []string{`@middleware-a(path:"/a"`, "@middleware-b"},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -701,7 +711,7 @@ func TestAddCode(t *testing.T) {
 				replace bool
 			}{
 				pkgs: map[string][]fun{
-					"../cases/case-01.md/code.go": []fun{
+					"../cases/case-01/code.go": []fun{
 						{
 							originIds: []string{
 								"@middleware-injection(path:\"\")",
@@ -732,7 +742,7 @@ func TestAddCode(t *testing.T) {
 				replace: false,
 			},
 			want: map[string][]string{
-				"../cases/case-01.md/code.go": []string{
+				"../cases/case-01/code.go": []string{
 					"@middleware-injection",
 				},
 			},
@@ -746,7 +756,7 @@ func TestAddCode(t *testing.T) {
 				replace bool
 			}{
 				pkgs: map[string][]fun{
-					"../cases/case-01.md/code.go": []fun{
+					"../cases/case-01/code.go": []fun{
 						{
 							originIds: []string{
 								"@middleware-injection(path:100)",
@@ -777,7 +787,7 @@ func TestAddCode(t *testing.T) {
 				replace: false,
 			},
 			want: map[string][]string{
-				"../cases/case-01.md/code.go": []string{
+				"../cases/case-01/code.go": []string{
 					"@middleware-injection",
 				},
 			},
@@ -791,7 +801,7 @@ func TestAddCode(t *testing.T) {
 				replace bool
 			}{
 				pkgs: map[string][]fun{
-					"../cases/case-01.md/code.go": []fun{
+					"../cases/case-01/code.go": []fun{
 						{
 							originIds: []string{
 								"@middleware-injection(path:100, name:\"a string param\")",
@@ -822,7 +832,7 @@ func TestAddCode(t *testing.T) {
 				replace: false,
 			},
 			want: map[string][]string{
-				"../cases/case-01.md/code.go": []string{
+				"../cases/case-01/code.go": []string{
 					"@middleware-injection",
 				},
 			},
@@ -836,7 +846,7 @@ func TestAddCode(t *testing.T) {
 				replace bool
 			}{
 				pkgs: map[string][]fun{
-					"../cases/case-01.md/code.go": []fun{
+					"../cases/case-01/code.go": []fun{
 						{
 							originIds: []string{
 								"@middleware-injection(path:\"/user/id\")",
@@ -867,7 +877,7 @@ func TestAddCode(t *testing.T) {
 				replace: false,
 			},
 			want: map[string][]string{
-				"../cases/case-01.md/code.go": []string{
+				"../cases/case-01/code.go": []string{
 					"@middleware-injection",
 				},
 			},
@@ -881,7 +891,7 @@ func TestAddCode(t *testing.T) {
 				replace bool
 			}{
 				pkgs: map[string][]fun{
-					"../cases/case-01.md/code.go": []fun{
+					"../cases/case-01/code.go": []fun{
 						{
 							originIds: []string{
 								"@middleware-injection(path:100, name:\"a string param\", f:@inject)",
@@ -912,7 +922,7 @@ func TestAddCode(t *testing.T) {
 				replace: false,
 			},
 			want: map[string][]string{
-				"../cases/case-01.md/code.go": []string{
+				"../cases/case-01/code.go": []string{
 					"@middleware-injection",
 				},
 			},
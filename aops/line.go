@@ -0,0 +1,145 @@
+package aops
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+)
+
+// lineAnchor is a line of woven source which should be attributed to line of file.
+type lineAnchor struct {
+	line   int
+	file   string
+	target int
+}
+
+// addLineDirectives add `//line` directives to the source of wf, so the original code keeps its position,
+// and the inserted code is attributed to the Source of its Stmt, like `//line /path/aop.toml:12`. If the
+// Stmt has no Source, the file is the middleware id and Stmt, like `//line @trace/Stmt[1]:1`.
+//
+// The directive is added before the first line of every inserted block, and before every original decl
+// and stmt whose line is shifted. The file names are absolute, since the directive is not relative to the
+// directory of file.
+func addLineDirectives(wf *wovenFile) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, wf.name, wf.src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	// printing keeps the structure of ast, so the nodes of f and wf.f are matched by order.
+	var woven, parsed []ast.Node
+	collect := func(file *ast.File, nodes *[]ast.Node) {
+		ast.Inspect(file, func(n ast.Node) bool {
+			switch t := n.(type) {
+			case *ast.GenDecl:
+				// the imports are added to the source only, they are not in wf.f.
+				if t.Tok == token.IMPORT {
+					return false
+				}
+				*nodes = append(*nodes, n)
+			case ast.Stmt, ast.Decl:
+				*nodes = append(*nodes, n)
+			}
+			return true
+		})
+	}
+	collect(wf.f, &woven)
+	collect(f, &parsed)
+	if len(woven) != len(parsed) {
+		return wf.src, nil
+	}
+
+	name := absPath(wf.name)
+	var anchors []lineAnchor
+	var injectedEnd token.Pos
+	for i, n := range woven {
+		p := parsed[i]
+		if p.Pos() < injectedEnd {
+			continue
+		}
+
+		line := fset.Position(p.Pos()).Line
+		if s, ok := n.(ast.Stmt); ok {
+			if o, exist := wf.origins[s]; exist {
+				file, target := originLine(o)
+				anchors = append(anchors, lineAnchor{line: line, file: file, target: target})
+				injectedEnd = p.End()
+				continue
+			}
+		}
+		anchors = append(anchors, lineAnchor{line: line, file: name, target: wf.fset.Position(n.Pos()).Line})
+	}
+
+	directives := make(map[int]string)
+	file, offset, last := name, 0, 0
+	for _, a := range anchors {
+		if a.line == last {
+			continue
+		}
+		last = a.line
+
+		if a.file != file || a.line+offset != a.target {
+			directives[a.line] = fmt.Sprintf("//line %s:%d", a.file, a.target)
+			file, offset = a.file, a.target-a.line
+		}
+	}
+	if len(directives) == 0 {
+		return wf.src, nil
+	}
+
+	lines := strings.Split(string(wf.src), "\n")
+	var b strings.Builder
+	for i, l := range lines {
+		if d, exist := directives[i+1]; exist {
+			b.WriteString(d)
+			b.WriteByte('\n')
+		}
+		b.WriteString(l)
+		if i < len(lines)-1 {
+			b.WriteByte('\n')
+		}
+	}
+
+	return []byte(b.String()), nil
+}
+
+// originLine get the file and line which the code of o is attributed to.
+func originLine(o stmtOrigin) (string, int) {
+	file, line := originSource(o)
+	if o.source.File != "" {
+		file = absPath(file)
+	}
+
+	return file, line
+}
+
+// originSource is the same as originLine, but the file of Source is kept as it is.
+func originSource(o stmtOrigin) (string, int) {
+	if o.source.File != "" {
+		if o.source.Line < 1 {
+			return o.source.File, 1
+		}
+		return o.source.File, o.source.Line
+	}
+
+	switch {
+	case o.decl:
+		return fmt.Sprintf("%s/DeclStmt[%d]", o.id, o.stmt), 1
+	case o.stmt < 0:
+		return o.id + "/arguments", 1
+	default:
+		return fmt.Sprintf("%s/Stmt[%d]", o.id, o.stmt), 1
+	}
+}
+
+func absPath(file string) string {
+	if abs, err := filepath.Abs(file); err == nil {
+		return abs
+	}
+
+	return file
+}
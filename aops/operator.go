@@ -3,6 +3,8 @@ package aops
 import (
 	"fmt"
 	"go/ast"
+	"go/token"
+	"go/types"
 	"strings"
 )
 
@@ -10,10 +12,199 @@ import (
 //
 // 1. addDeferWithoutVarOperator
 // 2. addFuncWithoutDependsOperator
-// 3. addStmtAsFuncWithVarOperator
-// 4. addStmtAsReturnOperator
-// 5. addReturnWithBindVarOperator
-// 6. addStmtBindVarOperator
+// 3. addStmtAsFuncWithoutVarOperator, for the around ctx stmts then the injected params
+// 4. addStmtAsFuncWithVarOperator
+// 5. addStmtAsReturnOperator
+// 6. addReturnWithBindVarOperator
+// 7. addStmtBindVarOperator
+//
+// The first three operators prepend code in the head of function body, so the aspect's code is laid
+// out as: injected params, around ctx stmts, the code bound to parameters by type, func stmts, defer stmts.
+// The injected params are the arguments of AOP id, they are declared before all the code, so every kind
+// can use them. The around ctx stmts replace the context of function, so the following code and the
+// original body get the new one, `__ctx__` in the code of the other kinds is the new context too.
+
+// weaveAspect Insert all stmts of one aspect to the function by the orders above.
+// When a function has several aspects, `AddCode` invokes weaveAspect from the innermost
+// aspect to the outermost one. The snippets of sp are rendered with jp before inserted.
+// info is the type check of package before weaving, which `bindDependType` uses, it can be nil.
+// If origins is not nil, the middleware id and Stmt of every inserted stmt are saved in it.
+func weaveAspect(t *ast.FuncDecl, a aspect, sp StmtParams, jp JoinPoint, info *types.Info, origins map[ast.Stmt]stmtOrigin) error {
+	err := CheckParams(a.id, sp.Params)
+	if err != nil {
+		return err
+	}
+	
+	args, err := parseArgs(a.originId)
+	if err == nil {
+		args, err = resolveArgs(a.originId, args, sp.Params)
+	}
+	if err != nil {
+		if ae, ok := err.(*AnnotationError); ok && a.pos.IsValid() {
+			// the position of argument is relative to the id, convert it to the position in source code.
+			ae.Pos = token.Position{
+				Filename: a.pos.Filename,
+				Offset:   a.pos.Offset + ae.Pos.Offset,
+				Line:     a.pos.Line,
+				Column:   a.pos.Column + ae.Pos.Column - 1,
+			}
+		}
+		return err
+	}
+	
+	jp.ID = a.id
+	jp.Args = make(map[string]string, len(args))
+	for _, arg := range args {
+		jp.Args[arg.name] = arg.value
+	}
+	
+	sp, err = renderStmtParams(a.id, sp, jp)
+	if err != nil {
+		return err
+	}
+	// rename the injected variables before the placeholders expand to the parameters of function.
+	taken := takenNames(t)
+	args = hygiene(sp, args, taken, paramNames(t))
+	
+	replacePlaceholder(sp, funcArgsPlaceHolder, getArgsExpr(t))
+	ctx := getFuncCtx(t, uniqueName(aroundCtxVarName, taken))
+	if hasKind(sp, AddAroundCtxStmt) {
+		replaceStmtPlaceholder(sp, funcCtxPlaceHolder, ctx.name)
+	}
+	replacePlaceholder(sp, funcCtxPlaceHolder, ctx.expr)
+	
+	err = CheckStmtParams(a.id, sp)
+	if err != nil {
+		return err
+	}
+	heads, err := bindDependType(t, sp, info)
+	if err != nil {
+		return fmt.Errorf("%s:%d: middleware %s: %v", jp.File, jp.Line, a.id, err)
+	}
+	
+	ij := injectDetail{
+		owner: a.owner,
+		name:  a.name,
+		jp:    jp,
+		args:  args,
+	}
+	
+	argStmts, err := ij.getArgsStmt(sp, args)
+	if err != nil {
+		return err
+	}
+	
+	arounds, err := ij.getAroundCtxStmt(sp, ctx)
+	if err != nil {
+		return err
+	}
+	
+	exprs, err := ij.getAddFuncWithoutDependsStmt(sp)
+	if err != nil {
+		return err
+	}
+	
+	stmts, err := ij.getDeferFuncStmt(sp)
+	if err != nil {
+		return err
+	}
+	
+	funcs, depends, funcDepends, stmtStr, err := ij.getFuncStmt(sp)
+	if err != nil {
+		return err
+	}
+	
+	rets, err := ij.getReturnFuncWithoutVarStmt(sp)
+	if err != nil {
+		return err
+	}
+	
+	retVars, retDepends, err := ij.getReturnFuncWithVarStmt(sp)
+	if err != nil {
+		return err
+	}
+	
+	// rec run the operator which inserts the code of sp.Stmts[stmt], and save the origin of inserted stmts.
+	// stmt is -1 for the declarations of arguments.
+	rec := func(stmt int, op func() error) error {
+		o := stmtOrigin{id: a.id, stmt: stmt}
+		if stmt >= 0 {
+			o.source = sp.Stmts[stmt].Source
+		}
+		return recordOrigins(t, origins, o, op)
+	}
+	
+	err = rec(kindIndex(sp, AddDeferFuncStmt), func() error {
+		return addDeferWithoutVarOperator(t, stmts)
+	})
+	if err != nil {
+		return err
+	}
+	
+	err = rec(kindIndex(sp, AddFuncWithoutDepends, AddFuncWithoutDependsWithInject), func() error {
+		return addFuncWithoutDependsOperator(t, exprs)
+	})
+	if err != nil {
+		return err
+	}
+	
+	// the code bound to parameter by type is inserted after the around ctx stmts, so it gets the new context.
+	for i := len(sp.Stmts) - 1; i >= 0; i-- {
+		code, exist := heads[i]
+		if !exist {
+			continue
+		}
+		headStmts, err := getStmtsFromStmt(code)
+		if err != nil {
+			return err
+		}
+		err = rec(i, func() error {
+			return addStmtAsFuncWithoutVarOperator(t, headStmts)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	
+	err = rec(kindIndex(sp, AddAroundCtxStmt), func() error {
+		return addStmtAsFuncWithoutVarOperator(t, arounds)
+	})
+	if err != nil {
+		return err
+	}
+	
+	err = rec(-1, func() error {
+		return addStmtAsFuncWithoutVarOperator(t, argStmts)
+	})
+	if err != nil {
+		return err
+	}
+	
+	err = rec(kindIndex(sp, AddFuncWithVarStmt), func() error {
+		return addStmtAsFuncWithVarOperator(t, funcs, depends, funcDepends, stmtStr)
+	})
+	if err != nil {
+		return err
+	}
+	
+	err = rec(kindIndex(sp, AddReturnFuncWithoutVarStmt), func() error {
+		return addStmtAsReturnOperator(t, rets)
+	})
+	if err != nil {
+		return err
+	}
+	
+	err = rec(kindIndex(sp, AddReturnFuncWithVarStmt), func() error {
+		return addReturnWithBindVarOperator(t, retVars, retDepends)
+	})
+	if err != nil {
+		return err
+	}
+	
+	return recordOrigins(t, origins, stmtOrigin{id: a.id, decl: true}, func() error {
+		return addStmtBindVarOperator(t, sp.DeclStmt)
+	})
+}
 
 // addReturnWithBindVarOperator Find the return function, then insert code in target function.
 // The variable depend on contains basic type, like int, string, error etc. Also, support function type,
@@ -0,0 +1,301 @@
+package aops
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// JoinPoint is the metadata of the function which an aspect applies to.
+// Every code snippet is rendered as a `text/template` with it before inserted, like that:
+//
+//	span := trace.Start("{{.FullName}}") // span := trace.Start("orders.(*Service).Create")
+//
+// ID is the middleware id.
+// IDs are all the AOP ids of the function from the outermost to the innermost.
+// Args are the annotation arguments of the id, key is the argument name and value is the source of
+// argument, e.g. `@trace(name:"create")` gets Args{"name": `"create"`}, so `{{.Args.name}}` is a valid
+// Go expression.
+// Receiver is the type of receiver, e.g. `*Service`, it is empty for functions.
+// ImportPath is the import path of Package, it is empty if the go.mod is not found.
+// File and Line are the position of function in source code.
+// Signature is the declaration of function without body, e.g. `func (s *Service) Create(id int) error`.
+// TypeParams are the type parameters of generic function, or of the receiver of method, e.g. `K comparable`
+// and `V any` of `func (c *Cache[K, V]) Get(k K) V`. The constraint of receiver's type parameter is found from
+// the type declaration in the same file, it is empty if the type is declared in another file.
+//
+// Since `{{` starts an action, a Go composite literal like `[]T{{1}}` in snippet should
+// be written as `[]T{ {1} }`.
+type JoinPoint struct {
+	ID         string
+	IDs        []string
+	FuncName   string
+	Receiver   string
+	Package    string
+	ImportPath string
+	File       string
+	Line       int
+	Signature  string
+	Params     []Field
+	Results    []Field
+	TypeParams []Field
+	Args       map[string]string
+}
+
+// Field is a parameter or result of function. Name is empty if it is unnamed.
+type Field struct {
+	Name string
+	Type string
+}
+
+// FullName returns the name like `runtime.FuncForPC`, e.g. `orders.(*Service).Create`,
+// `orders.Service.Get` or `orders.Create`. The type arguments are written as `[...]`, e.g.
+// `orders.(*Cache[...]).Get` or `orders.Map[...]`.
+func (jp JoinPoint) FullName() string {
+	receiver := jp.Receiver
+	if i := strings.Index(receiver, "["); i >= 0 {
+		receiver = receiver[:i] + "[...]"
+	}
+
+	switch {
+	case receiver == "" && len(jp.TypeParams) > 0:
+		return fmt.Sprintf("%s.%s[...]", jp.Package, jp.FuncName)
+	case receiver == "":
+		return fmt.Sprintf("%s.%s", jp.Package, jp.FuncName)
+	case strings.HasPrefix(receiver, "*"):
+		return fmt.Sprintf("%s.(%s).%s", jp.Package, receiver, jp.FuncName)
+	default:
+		return fmt.Sprintf("%s.%s.%s", jp.Package, receiver, jp.FuncName)
+	}
+}
+
+// TypeArgs returns the type parameters as the type arguments, e.g. `[K, V]`, so a snippet can instantiate
+// a generic with them, like `var zero Cache{{.TypeArgs}}`, unless a type parameter of receiver is `_`.
+// It is empty if there is no type parameter.
+func (jp JoinPoint) TypeArgs() string {
+	if len(jp.TypeParams) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(jp.TypeParams))
+	for _, p := range jp.TypeParams {
+		names = append(names, p.Name)
+	}
+
+	return "[" + strings.Join(names, ", ") + "]"
+}
+
+// newJoinPoint get the metadata of fd, f is the file which fd belongs to.
+func newJoinPoint(fset *token.FileSet, f *ast.File, importPath string, fd *ast.FuncDecl) JoinPoint {
+	pos := fset.Position(fd.Pos())
+	jp := JoinPoint{
+		FuncName:   fd.Name.Name,
+		Package:    f.Name.Name,
+		ImportPath: importPath,
+		File:       pos.Filename,
+		Line:       pos.Line,
+		Params:     getFields(fset, fd.Type.Params),
+		Results:    getFields(fset, fd.Type.Results),
+	}
+	if fd.Recv != nil && len(fd.Recv.List) > 0 {
+		jp.Receiver = exprString(fset, fd.Recv.List[0].Type)
+		jp.TypeParams = receiverTypeParams(fset, f, fd)
+	} else {
+		jp.TypeParams = getFields(fset, fd.Type.TypeParams)
+	}
+
+	var buf bytes.Buffer
+	printer.Fprint(&buf, fset, &ast.FuncDecl{Recv: fd.Recv, Name: fd.Name, Type: fd.Type})
+	jp.Signature = buf.String()
+
+	return jp
+}
+
+// getFields convert fl to Field list, `a, b int` is converted to two fields.
+func getFields(fset *token.FileSet, fl *ast.FieldList) (fields []Field) {
+	if fl == nil {
+		return
+	}
+
+	for _, f := range fl.List {
+		typ := exprString(fset, f.Type)
+		if len(f.Names) == 0 {
+			fields = append(fields, Field{Type: typ})
+			continue
+		}
+		for _, n := range f.Names {
+			fields = append(fields, Field{Name: n.Name, Type: typ})
+		}
+	}
+
+	return
+}
+
+// receiverTypeParams get the type parameters of the receiver of fd, e.g. `K` and `V` of `*Cache[K, V]`.
+// The constraints are copied from the declaration of receiver type in f by order.
+func receiverTypeParams(fset *token.FileSet, f *ast.File, fd *ast.FuncDecl) (fields []Field) {
+	e := fd.Recv.List[0].Type
+	if star, ok := e.(*ast.StarExpr); ok {
+		e = star.X
+	}
+
+	var indices []ast.Expr
+	switch t := e.(type) {
+	case *ast.IndexExpr:
+		indices = []ast.Expr{t.Index}
+	case *ast.IndexListExpr:
+		indices = t.Indices
+	default:
+		return nil
+	}
+
+	var constraints []Field
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			if ts := spec.(*ast.TypeSpec); ts.Name.Name == receiverName(fd) {
+				constraints = getFields(fset, ts.TypeParams)
+			}
+		}
+	}
+
+	for i, index := range indices {
+		field := Field{Name: exprString(fset, index)}
+		if i < len(constraints) {
+			field.Type = constraints[i].Type
+		}
+		fields = append(fields, field)
+	}
+
+	return
+}
+
+func exprString(fset *token.FileSet, e ast.Expr) string {
+	var buf bytes.Buffer
+	printer.Fprint(&buf, fset, e)
+	return buf.String()
+}
+
+// getImportPath find the go.mod from the dir of file to the root, and join the module path with
+// the relative dir of file. Return empty string if go.mod is not found.
+func getImportPath(file string) string {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return ""
+	}
+
+	dir := filepath.Dir(abs)
+	for d := dir; ; d = filepath.Dir(d) {
+		if module := getModulePath(filepath.Join(d, "go.mod")); module != "" {
+			rel, err := filepath.Rel(d, dir)
+			if err != nil {
+				return ""
+			}
+			if rel == "." {
+				return module
+			}
+			return module + "/" + filepath.ToSlash(rel)
+		}
+
+		if filepath.Dir(d) == d {
+			return ""
+		}
+	}
+}
+
+// getModulePath read the module path from go.mod, return empty string if gomod can not be read.
+func getModulePath(gomod string) string {
+	f, err := os.Open(gomod)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`)
+		}
+	}
+
+	return ""
+}
+
+// isTemplate check whether snippet contains template actions.
+func isTemplate(snippet string) bool {
+	return strings.Contains(snippet, "{{")
+}
+
+// parseTemplate parse snippet as a template, the missing key of Args is rendered as empty string.
+func parseTemplate(snippet string) (*template.Template, error) {
+	return template.New("snippet").Option("missingkey=zero").Parse(snippet)
+}
+
+// render the snippet with jp. The snippet without actions is returned directly.
+func render(snippet string, jp JoinPoint) (string, error) {
+	if !isTemplate(snippet) {
+		return snippet, nil
+	}
+
+	t, err := parseTemplate(snippet)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, jp); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// renderStmtParams render all the snippets of sp with jp, and return the rendered copy.
+// The template error is returned as *SnippetError.
+func renderStmtParams(id string, sp StmtParams, jp JoinPoint) (StmtParams, error) {
+	result := sp
+	if sp.Stmts != nil {
+		result.Stmts = make([]StmtParam, len(sp.Stmts))
+	}
+	for i, s := range sp.Stmts {
+		s.Stmt = append([]string(nil), s.Stmt...)
+		for k := range s.Stmt {
+			code, err := render(s.Stmt[k], jp)
+			if err != nil {
+				return sp, newSnippetError(id, i, k, s.Source, s.Stmt[k], err)
+			}
+			s.Stmt[k] = code
+		}
+		result.Stmts[i] = s
+	}
+
+	if sp.DeclStmt != nil {
+		result.DeclStmt = make([]DeclParams, len(sp.DeclStmt))
+	}
+	for i, d := range sp.DeclStmt {
+		d.Stmt = append([]string(nil), d.Stmt...)
+		for k := range d.Stmt {
+			code, err := render(d.Stmt[k], jp)
+			if err != nil {
+				se := newSnippetError(id, i, k, Source{}, d.Stmt[k], err)
+				se.Decl = true
+				return sp, se
+			}
+			d.Stmt[k] = code
+		}
+		result.DeclStmt[i] = d
+	}
+
+	return result, nil
+}
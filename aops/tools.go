@@ -2,71 +2,300 @@ package aops
 
 import (
 	"fmt"
-	zs "github.com/andy-zhangtao/gogather/strings"
 	"go/ast"
 	"go/parser"
 	"go/scanner"
 	"go/token"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 )
 
+// injectDetail jp and args are the metadata and the arguments of AOP id, which `@inject` uses.
 type injectDetail struct {
 	owner string
 	name  string
+	jp    JoinPoint
+	args  []annotationArg
 }
 
-func (ij injectDetail) getAddFuncWithoutDependsStmt(sp StmtParams, id string) (stmt []ast.Stmt, expr []ast.Expr, err error) {
+func (ij injectDetail) getAddFuncWithoutDependsStmt(sp StmtParams) (expr []ast.Expr, err error) {
 	for _, s := range sp.Stmts {
 		switch s.Kind {
-		case AddFuncWithoutDepends:
-			expr, err = getExprsFromStmt(s.Stmt)
-			return nil, expr, err
-		case AddFuncWithoutDependsWithInject:
-			//	parser the id param, and declare these params
-			params, err := ij.getParamsFromID(id)
-			if err != nil {
-				return nil, nil, err
+		case AddFuncWithoutDepends, AddFuncWithoutDependsWithInject:
+			return getExprsFromStmt(s.Stmt)
+		}
+	}
+	
+	return
+}
+
+// getArgsStmt declare the arguments of AOP id, so the code of every kind can use them.
+// If sp has AddFuncWithoutDependsWithInject, all the arguments are declared, the ones unused by code
+// are assigned to `_`. Otherwise, only the arguments used by code are declared, since the unused
+// variable can not compile.
+func (ij injectDetail) getArgsStmt(sp StmtParams, args []annotationArg) ([]ast.Stmt, error) {
+	used := usedIdents(sp)
+	inject := hasKind(sp, AddFuncWithoutDependsWithInject)
+	var declared []annotationArg
+	var unused []string
+	for _, a := range args {
+		switch {
+		case used[a.varName()]:
+			declared = append(declared, a)
+		case inject:
+			declared = append(declared, a)
+			unused = append(unused, "_ = "+a.varName())
+		}
+	}
+	
+	return getStmtsFromStmt(append(ij.getParamsDeclare(declared), unused...))
+}
+
+// usedIdents get the identifiers in all the code of sp. The selectors, like `name` in `x.name`,
+// are not identifiers.
+func usedIdents(sp StmtParams) map[string]bool {
+	var codes []string
+	for _, s := range sp.Stmts {
+		codes = append(codes, s.Stmt...)
+	}
+	for _, d := range sp.DeclStmt {
+		codes = append(codes, d.Stmt...)
+	}
+	
+	used := make(map[string]bool)
+	for _, c := range codes {
+		var s scanner.Scanner
+		fset := token.NewFileSet()
+		s.Init(fset.AddFile("", fset.Base(), len(c)), []byte(c), nil, 0)
+		
+		prev := token.ILLEGAL
+		for {
+			_, tok, lit := s.Scan()
+			if tok == token.EOF {
+				break
+			}
+			if tok == token.IDENT && prev != token.PERIOD {
+				used[lit] = true
+			}
+			prev = tok
+		}
+	}
+	
+	return used
+}
+
+// getArgsExpr get the expression that `__args__` expands to, it is a []inject.Arg contains all the named
+// parameters of fd, includes the receiver and variadic parameter. The parameters marked by `//goaop:sensitive`
+// in doc comment are skipped.
+func getArgsExpr(fd *ast.FuncDecl) string {
+	sensitive := make(map[string]bool)
+	if fd.Doc != nil {
+		for _, c := range fd.Doc.List {
+			if strings.HasPrefix(c.Text, sensitiveDirective) {
+				names := strings.FieldsFunc(strings.TrimPrefix(c.Text, sensitiveDirective), func(r rune) bool {
+					return r == ',' || r == ' ' || r == '\t'
+				})
+				for _, n := range names {
+					sensitive[n] = true
+				}
+			}
+		}
+	}
+	
+	var fields []*ast.Field
+	if fd.Recv != nil {
+		fields = append(fields, fd.Recv.List...)
+	}
+	fields = append(fields, fd.Type.Params.List...)
+	
+	var args []string
+	for _, f := range fields {
+		for _, n := range f.Names {
+			if n.Name == "_" || sensitive[n.Name] {
+				continue
+			}
+			args = append(args, fmt.Sprintf("inject.Arg{Name: %q, Value: %s}", n.Name, n.Name))
+		}
+	}
+	
+	return fmt.Sprintf("[]inject.Arg{%s}", strings.Join(args, ", "))
+}
+
+// replacePlaceholder replace placeholder with value in all the code of sp. sp should be
+// the copy returned by `renderStmtParams`, since the code is replaced in place.
+func replacePlaceholder(sp StmtParams, placeholder, value string) {
+	replaceStmtPlaceholder(sp, placeholder, value)
+	for _, d := range sp.DeclStmt {
+		for k := range d.Stmt {
+			d.Stmt[k] = strings.Replace(d.Stmt[k], placeholder, value, -1)
+		}
+	}
+}
+
+// replaceStmtPlaceholder is the same as replacePlaceholder, but only replaces the code of Stmts,
+// the code of DeclStmt is kept.
+func replaceStmtPlaceholder(sp StmtParams, placeholder, value string) {
+	for _, s := range sp.Stmts {
+		for k := range s.Stmt {
+			s.Stmt[k] = strings.Replace(s.Stmt[k], placeholder, value, -1)
+		}
+	}
+}
+
+// funcCtx is the context of function which `__ctx__` stands for.
+// expr is the expression of context, name is the variable which the code of AddAroundCtxStmt
+// assigns the new context to. decl and after are the stmts before and after that code.
+type funcCtx struct {
+	expr  string
+	name  string
+	decl  []string
+	after []string
+}
+
+// getFuncCtx find the context of fd by the order:
+//   - the `context.Context` parameter, e.g. `ctx`. It is assigned directly, so the body gets the new context.
+//   - the `*http.Request` parameter, e.g. `r.Context()`. The new context is assigned to the variable
+//     name, then `r = r.WithContext(name)` passes it to the body.
+//   - `context.Background()`, the new context is only visible to the aspect.
+//
+// If the aspect has AddAroundCtxStmt, `__ctx__` in all its stmts expands to name, so the code of the other
+// kinds gets the new context too. The code of DeclStmt is outside of function, it always gets expr.
+// name is aopCtx, or the unique one if aopCtx collides with the function.
+func getFuncCtx(fd *ast.FuncDecl, name string) funcCtx {
+	var request string
+	for _, f := range fd.Type.Params.List {
+		for _, n := range f.Names {
+			if n.Name == "_" {
+				continue
+			}
+			if isSelector(f.Type, "context", "Context") {
+				return funcCtx{expr: n.Name, name: n.Name}
 			}
-			
-			paramStmt, err := getStmtsFromStmt(params)
-			if err != nil {
-				return nil, nil, err
+			if star, ok := f.Type.(*ast.StarExpr); ok && request == "" && isSelector(star.X, "http", "Request") {
+				request = n.Name
 			}
-			
-			expr, err = getExprsFromStmt(s.Stmt)
-			return paramStmt, expr, err
 		}
 	}
 	
+	if request != "" {
+		return funcCtx{
+			expr:  request + ".Context()",
+			name:  name,
+			decl:  []string{fmt.Sprintf("%s := %s.Context()", name, request)},
+			after: []string{fmt.Sprintf("%s = %s.WithContext(%s)", request, request, name)},
+		}
+	}
+	
+	return funcCtx{
+		expr:  "context.Background()",
+		name:  name,
+		decl:  []string{name + " := context.Background()"},
+		after: []string{"_ = " + name},
+	}
+}
+
+// placeholderPacks get the packs which the expanded placeholders of sp in fd use: `inject` for `__args__`,
+// and `context` for `context.Background()` of `__ctx__` or AddAroundCtxStmt, so they need not be declared
+// by hand.
+func placeholderPacks(fd *ast.FuncDecl, sp StmtParams) (packs []Pack) {
+	used := func(placeholder string) bool {
+		for _, s := range sp.Stmts {
+			for _, code := range s.Stmt {
+				if strings.Contains(code, placeholder) {
+					return true
+				}
+			}
+		}
+		for _, d := range sp.DeclStmt {
+			for _, code := range d.Stmt {
+				if strings.Contains(code, placeholder) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	
+	if used(funcArgsPlaceHolder) {
+		packs = append(packs, Pack{Path: injectImportPath})
+	}
+	if (used(funcCtxPlaceHolder) || hasKind(sp, AddAroundCtxStmt)) && getFuncCtx(fd, aroundCtxVarName).expr == "context.Background()" {
+		packs = append(packs, Pack{Path: `"context"`})
+	}
+	
 	return
 }
 
-func (ij injectDetail) getParamsFromID(id string) ([]string, error) {
-	var params []string
-	paths, err := zs.SymExstact(id, "(", ")")
-	if err != nil {
-		return nil, err
+// isSelector check whether e is `x.sel`.
+func isSelector(e ast.Expr, x, sel string) bool {
+	se, ok := e.(*ast.SelectorExpr)
+	if !ok {
+		return false
 	}
+	id, ok := se.X.(*ast.Ident)
 	
-	_paths := strings.Split(paths[0], ",")
-	for _, p := range _paths {
-		_p := strings.Split(p, ":")
-		if len(_p) == 2 {
-			params = append(params, ij.getVariableDeclare(_p))
+	return ok && id.Name == x && se.Sel.Name == sel
+}
+
+// getAroundCtxStmt get the stmts of AddAroundCtxStmt with the declaration and assignment of ctx around them.
+// The declaration and assignment are omitted if the code does not use ctx.
+func (ij injectDetail) getAroundCtxStmt(sp StmtParams, ctx funcCtx) ([]ast.Stmt, error) {
+	for _, s := range sp.Stmts {
+		if s.Kind != AddAroundCtxStmt {
+			continue
+		}
+		if !usedIdents(StmtParams{Stmts: []StmtParam{s}})[ctx.name] {
+			return getStmtsFromStmt(s.Stmt)
+		}
+		code := append(append(append([]string(nil), ctx.decl...), s.Stmt...), ctx.after...)
+		return getStmtsFromStmt(code)
+	}
+	
+	return nil, nil
+}
+
+// hasKind check whether sp has stmt of kind.
+func hasKind(sp StmtParams, kind OperationKind) bool {
+	for _, s := range sp.Stmts {
+		if s.Kind == kind {
+			return true
 		}
 	}
 	
-	return params, nil
+	return false
 }
 
-func (ij injectDetail) getVariableDeclare(id []string) string {
-	val := strings.TrimSpace(id[1])
-	switch val {
-	case aopInjectLabel:
-		return fmt.Sprintf("%s := %v", strings.TrimSpace(id[0]), ij._getAOPInjectLabel())
+// getParamsDeclare get the declarations of args.
+func (ij injectDetail) getParamsDeclare(args []annotationArg) []string {
+	var params []string
+	for _, a := range args {
+		params = append(params, ij.getVariableDeclare(a))
+	}
+	
+	return params
+}
+
+// getVariableDeclare declare a by `:=`, or `var` if a has type. The argument without value
+// is declared as the zero value.
+func (ij injectDetail) getVariableDeclare(a annotationArg) string {
+	val := strings.TrimSpace(a.value)
+	if val == aopInjectLabel {
+		if a.typ == aopJoinPointType {
+			val = ij._getAOPJoinPoint()
+		} else {
+			val = ij._getAOPInjectLabel()
+		}
+	}
+	
+	switch {
+	case a.typ == "":
+		return fmt.Sprintf("%s := %v", a.varName(), val)
+	case val == "":
+		return fmt.Sprintf("var %s %s", a.varName(), a.typ)
 	default:
-		return fmt.Sprintf("%s := %v", strings.TrimSpace(id[0]), val)
+		return fmt.Sprintf("var %s %s = %v", a.varName(), a.typ, val)
 	}
 }
 
@@ -79,6 +308,42 @@ func (ij injectDetail) _getAOPInjectLabel() string {
 	return fmt.Sprintf(str, ij.name, ij.owner)
 }
 
+// _getAOPJoinPoint get the inject.JoinPoint of function, the Args are the arguments except `@inject`.
+func (ij injectDetail) _getAOPJoinPoint() string {
+	pkg, file := ij.jp.ImportPath, filepath.Base(ij.jp.File)
+	if pkg == "" {
+		pkg = ij.jp.Package
+	} else {
+		file = pkg + "/" + file
+	}
+	
+	ids := make([]string, 0, len(ij.jp.IDs))
+	for _, id := range ij.jp.IDs {
+		ids = append(ids, strconv.Quote(id))
+	}
+	
+	var args []string
+	for _, a := range ij.args {
+		if a.value != "" && a.value != aopInjectLabel {
+			args = append(args, fmt.Sprintf("%q: %s", a.name, a.value))
+		}
+	}
+	
+	str := `inject.Begin(inject.JoinPoint{
+		AOPLabel:  %s,
+		Func:      %q,
+		Package:   %q,
+		File:      %q,
+		Line:      %d,
+		Signature: %q,
+		IDs:       []string{%s},
+		Args:      map[string]interface{}{%s},
+	})`
+	
+	return fmt.Sprintf(str, ij._getAOPInjectLabel(), ij.jp.FullName(), pkg, file, ij.jp.Line, ij.jp.Signature,
+		strings.Join(ids, ", "), strings.Join(args, ", "))
+}
+
 func (ij injectDetail) getDeferFuncStmt(sp StmtParams) (stmts []ast.Stmt, err error) {
 	return getStmt(sp.Stmts, AddDeferFuncStmt)
 }
@@ -188,42 +453,90 @@ func getIntersection(arr []string, ids map[string]struct{}) []string {
 // are valid
 // The id can not end with '@', likes that '@','@middleware@'
 // are invalid
+// The arguments in `()` follow the id are kept, like `@middleware-c(path:"/a, b")`. The
+// spaces and `()` in strings of arguments are not the end of id.
 func extractIdFromComment(comment string) []string {
 	var result []string
-	comments := strings.Split(comment, "//")
-	for _, _comment := range comments {
-		comments := strings.Split(_comment, " ")
-		for _, c := range comments {
-			if strings.HasPrefix(c, "@") {
-				_c := strings.TrimSpace(c)
-				if len(_c) > 1 &&
-					!strings.HasSuffix(_c, "@") {
-					result = append(result, strings.TrimSpace(c))
+	for i := 0; i < len(comment); i++ {
+		if comment[i] != '@' || (i > 0 && !strings.ContainsRune(" \t\n/", rune(comment[i-1]))) {
+			continue
+		}
+		
+		end := i
+		for end < len(comment) && !strings.ContainsRune(" \t\r\n(", rune(comment[end])) {
+			end++
+		}
+		
+		name := comment[i:end]
+		if end < len(comment) && comment[end] == '(' {
+			end = argsEnd(comment, end)
+		}
+		
+		if len(name) > 1 && !strings.HasSuffix(name, "@") {
+			result = append(result, strings.TrimSpace(comment[i:end]))
+		}
+		i = end - 1
+	}
+	
+	return result
+}
+
+// argsEnd get the end of arguments which starts with '(' at start. The `()` in strings
+// and nested `()` are skipped. If the arguments are not closed, return the end of line.
+func argsEnd(comment string, start int) int {
+	depth := 0
+	for i := start; i < len(comment); i++ {
+		switch c := comment[i]; c {
+		case '"', '\'', '`':
+			// skip the string, `\` escapes the next char except in raw string.
+			for i++; i < len(comment) && comment[i] != c && comment[i] != '\n'; i++ {
+				if c != '`' && comment[i] == '\\' {
+					i++
 				}
 			}
+			if i >= len(comment) || comment[i] == '\n' {
+				return i
+			}
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		case '\n':
+			return i
 		}
 	}
 	
-	return result
+	return len(comment)
 }
 
 // parserStmt Convert stmt to ast.Stmt
 // If the stmt is a valid stmt, then return ast.Stmt.
 // Otherwise, return a error.
 func parserStmt(stmt string) (ast.Stmt, error) {
-	expr := "func(){" + stmt + ";}"
+	// Use newline instead of ';' to end stmt, so the comment at the end of stmt will not eat '}'.
+	expr := "func(){" + stmt + "\n}"
 	if e, err := parser.ParseExpr(expr); err != nil {
 		if e, err := parser.ParseExpr(stmt); err == nil {
 			return &ast.ExprStmt{X: e}, nil
 		}
 		errs := err.(scanner.ErrorList)
 		for i := range errs {
+			// Only the first line is prefixed by `func(){`
 			errs[i].Pos.Offset -= 7
-			errs[i].Pos.Column -= 7
+			if errs[i].Pos.Line == 1 {
+				errs[i].Pos.Column -= 7
+			}
 		}
 		return nil, errs
 	} else {
-		node := e.(*ast.FuncLit).Body.List[0]
+		body := e.(*ast.FuncLit).Body
+		if len(body.List) == 0 {
+			return nil, fmt.Errorf("empty stmt")
+		}
+		node := body.List[0]
 		if stmt, ok := node.(ast.Stmt); !ok {
 			return nil, fmt.Errorf("%T not supported", node)
 		} else {
@@ -232,43 +545,100 @@ func parserStmt(stmt string) (ast.Stmt, error) {
 	}
 }
 
-func isEqual(fd *ast.FuncDecl, fn fun) bool {
-	if fd.Recv != nil && len(fd.Recv.List) > 0 && fn.owner != "" {
-		owner := ""
-		switch t := fd.Recv.List[0].Type.(type) {
-		case *ast.Ident:
-			owner = t.Name
-		case *ast.StarExpr:
-			owner = t.X.(*ast.Ident).Name
+// aspect is an AOP id that applies to a function.
+// originId is the id with its params in comment, like `@middleware-c(path:"xxx")`.
+// pos is the position of originId in source code, it is set by `AddCode`.
+type aspect struct {
+	id       string
+	originId string
+	owner    string
+	name     string
+	pos      token.Position
+}
+
+// sortAspects collect all aspects of fd from funs, and sort them from the outermost to the innermost.
+// The aspect has smaller StmtParams.Order is outer. If two aspects have the same order,
+// the one comes first in comment is outer.
+func sortAspects(fd *ast.FuncDecl, funs []fun, stmt map[string]StmtParams) []aspect {
+	var aspects []aspect
+	for _, fn := range funs {
+		if !isEqual(fd, fn) {
+			continue
+		}
+		
+		for _, id := range fn.aopIds {
+			aspects = append(aspects, aspect{
+				id:       id,
+				originId: getOriginId(fn.originIds, id),
+				owner:    fn.owner,
+				name:     fn.name,
+			})
 		}
-		return owner == fn.owner && fd.Name.String() == fn.name
 	}
 	
-	if fd.Recv == nil && fn.owner == "" {
-		return fd.Name.String() == fn.name
-	}
+	sort.SliceStable(aspects, func(i, j int) bool {
+		return stmt[aspects[i].id].Order < stmt[aspects[j].id].Order
+	})
 	
-	if fd.Recv != nil && fn.owner == "" {
-		return false
+	return aspects
+}
+
+// getOriginId find the origin id which matches AOP id from comment ids.
+// If no one matches, return the AOP id itself.
+func getOriginId(originIds []string, id string) string {
+	for _, o := range originIds {
+		if extractFuncName(o) == id {
+			return o
+		}
 	}
 	
-	return false
+	return id
+}
+
+func isEqual(fd *ast.FuncDecl, fn fun) bool {
+	return receiverName(fd) == fn.owner && fd.Name.String() == fn.name
 }
 
 func fullId(t *ast.FuncDecl) string {
-	r := ""
-	name := t.Name.String()
-	if t.Recv != nil && len(t.Recv.List) > 0 {
-		switch _t := t.Recv.List[0].Type.(type) {
+	return fmt.Sprintf("%s-%s", t.Name.String(), receiverName(t))
+}
+
+// receiverName get the type name of the receiver of fd, e.g. `Cache` of `*Cache[K, V]`.
+// It is noReceiver if fd is a function.
+func receiverName(fd *ast.FuncDecl) string {
+	if fd.Recv == nil || len(fd.Recv.List) == 0 {
+		return noReceiver
+	}
+	
+	e := fd.Recv.List[0].Type
+	for {
+		switch t := e.(type) {
 		case *ast.Ident:
-			r = _t.Name
+			return t.Name
 		case *ast.StarExpr:
-			r = _t.X.(*ast.Ident).Name
+			e = t.X
+		case *ast.ParenExpr:
+			e = t.X
+		case *ast.IndexExpr:
+			e = t.X
+		case *ast.IndexListExpr:
+			e = t.X
+		default:
+			return noReceiver
 		}
-		//r = t.Recv.List[0].Type.(*ast.Ident).Name
 	}
+}
+
+// sortedFiles get all file names from pkgs by order. Since map iteration order is random,
+// use sortedFiles keep the process and output order stable.
+func sortedFiles(pkgs map[string][]fun) []string {
+	names := make([]string, 0, len(pkgs))
+	for name := range pkgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 	
-	return fmt.Sprintf("%s-%s", name, r)
+	return names
 }
 
 // removeDuplicate m is generated by AddCode, save the file path and AOP ids.
@@ -291,6 +661,15 @@ func removeDuplicate(m map[string][]string) map[string][]string {
 	return m
 }
 
+// importKey is the name and path of import, like `log "github.com/sirupsen/logrus"`.
+func importKey(i *ast.ImportSpec) string {
+	if i.Name == nil {
+		return i.Path.Value
+	}
+	
+	return i.Name.Name + " " + i.Path.Value
+}
+
 func parserImport(p Pack) (impor []ast.Spec, err error) {
 	comment := fmt.Sprintf(`package main
 import %s %s
@@ -1,11 +1,19 @@
 package aops
 
 import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 )
 
-func Test_getParamsFromID(t *testing.T) {
+func Test_getParamsDeclare(t *testing.T) {
 	type args struct {
 		id string
 	}
@@ -32,17 +40,546 @@ func Test_getParamsFromID(t *testing.T) {
 				`name := 33`,
 			},
 		},
+		{
+			name:    "no params test",
+			args:    args{id: `@middleware-injection`},
+			wantErr: false,
+		},
+		{
+			name:    "bad params test",
+			args:    args{id: `@middleware-injection(path:)`},
+			wantErr: true,
+		},
 	}
 	ij := injectDetail{}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := ij.getParamsFromID(tt.args.id)
+			args, err := parseArgs(tt.args.id)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("getParamsFromID() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("parseArgs() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got := ij.getParamsDeclare(args); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getParamsDeclare() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckStmt(t *testing.T) {
+	type args struct {
+		id  string
+		idx int
+		sp  StmtParam
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr string
+	}{
+		{
+			name: "valid stmt",
+			args: args{id: "@a", idx: 0, sp: StmtParam{
+				Kind: AddDeferFuncStmt,
+				Stmt: []string{"defer fmt.Println(1) // comment"},
+			}},
+		},
+		{
+			name: "invalid stmt from config",
+			args: args{id: "@a", idx: 1, sp: StmtParam{
+				Kind:   AddDeferFuncStmt,
+				Stmt:   []string{"defer fmt.Println(1)", "defer fmt.Println(1"},
+				Source: Source{File: "aop.toml", Line: 12},
+			}},
+			wantErr: "aop.toml:12: middleware @a: Stmt[1] code[1]: 1:20: missing ',' before newline in argument list\n" +
+				"\tdefer fmt.Println(1\n" +
+				"\t                   ^",
+		},
+		{
+			name: "invalid multi-line stmt",
+			args: args{id: "@a", idx: 0, sp: StmtParam{
+				Kind: AddDeferFuncStmt,
+				Stmt: []string{"defer func() {\n\tfmt.Println(1 2)\n}()"},
+			}},
+			wantErr: "middleware @a: Stmt[0] code[0]: 2:16: missing ',' in argument list\n" +
+				"\t\tfmt.Println(1 2)\n" +
+				"\t\t              ^",
+		},
+		{
+			name: "invalid expr",
+			args: args{id: "@a", idx: 0, sp: StmtParam{
+				Kind: AddFuncWithoutDepends,
+				Stmt: []string{"defer fmt.Println(1)"},
+			}},
+			wantErr: "middleware @a: Stmt[0] code[0]: 1:1: expected operand, found 'defer'\n" +
+				"\tdefer fmt.Println(1)\n" +
+				"\t^",
+		},
+		{
+			name: "empty stmt",
+			args: args{id: "@a", idx: 0, sp: StmtParam{
+				Kind: AddDeferFuncStmt,
+				Stmt: []string{"// only comment"},
+			}},
+			wantErr: "middleware @a: Stmt[0] code[0]: empty stmt",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckStmt(tt.args.id, tt.args.idx, tt.args.sp)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("CheckStmt() error = %v, want nil", err)
+				}
+				return
+			}
+			
+			if _, ok := err.(*SnippetError); !ok {
+				t.Fatalf("CheckStmt() error = %T, want *SnippetError", err)
+			}
+			if err.Error() != tt.wantErr {
+				t.Errorf("CheckStmt() error = %q, want %q", err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_getImportPath(t *testing.T) {
+	tests := []struct {
+		file string
+		want string
+	}{
+		{file: "tools.go", want: "github.com/runways/goAOP/aops"},
+		{file: "../cases/join-point/code.go", want: "github.com/runways/goAOP/cases/join-point"},
+		{file: "/code.go", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			if got := getImportPath(tt.file); got != tt.want {
+				t.Errorf("getImportPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_renderStmtParams(t *testing.T) {
+	jp := JoinPoint{FuncName: "Create", Receiver: "*Service", Package: "orders"}
+	sp := StmtParams{Stmts: []StmtParam{{
+		Kind: AddFuncWithoutDepends,
+		Stmt: []string{`fmt.Println("{{.FullName}}")`, `fmt.Println("{{.Unknown}}")`},
+	}}}
+	
+	_, err := renderStmtParams("@trace", sp, jp)
+	se, ok := err.(*SnippetError)
+	if !ok || se.Code != 1 || !strings.Contains(se.Msg, "can't evaluate field Unknown") {
+		t.Fatalf("renderStmtParams() error = %v, want the error of code[1]", err)
+	}
+	
+	sp.Stmts[0].Stmt = sp.Stmts[0].Stmt[:1]
+	got, err := renderStmtParams("@trace", sp, jp)
+	if err != nil {
+		t.Fatalf("renderStmtParams() error = %v", err)
+	}
+	if want := `fmt.Println("orders.(*Service).Create")`; got.Stmts[0].Stmt[0] != want {
+		t.Errorf("renderStmtParams() got = %v, want %v", got.Stmts[0].Stmt[0], want)
+	}
+	if sp.Stmts[0].Stmt[0] != `fmt.Println("{{.FullName}}")` {
+		t.Errorf("renderStmtParams() modified the origin StmtParams")
+	}
+}
+
+func Test_parseArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      string
+		want    [][]string
+		wantErr string
+	}{
+		{
+			name: "no arguments",
+			id:   "@trace",
+		},
+		{
+			name: "empty arguments",
+			id:   "@trace()",
+		},
+		{
+			name: "separators in string",
+			id:   `@trace(path:"/a,b", msg:"a:b", space:" ( x ) ")`,
+			want: [][]string{{"path", `"/a,b"`}, {"msg", `"a:b"`}, {"space", `" ( x ) "`}},
+		},
+		{
+			name: "literals",
+			id:   `@trace(qps:100, ratio:0.5, on:true, timeout:3*time.Second, tags:[]string{"a", "b"}, f:@inject)`,
+			want: [][]string{
+				{"qps", "100"},
+				{"ratio", "0.5"},
+				{"on", "true"},
+				{"timeout", "3*time.Second"},
+				{"tags", `[]string{"a", "b"}`},
+				{"f", "@inject"},
+			},
+		},
+		{
+			name: "composite literal",
+			id:   `@trace(opt:Option{Name: "x", Retry: f(1, 2)})`,
+			want: [][]string{{"opt", `Option{Name: "x", Retry: f(1, 2)}`}},
+		},
+		{
+			name:    "missing value",
+			id:      `@trace(name:"x", qps:)`,
+			wantErr: "1:22: annotation @trace: expected operand, found '}'",
+		},
+		{
+			name:    "missing name",
+			id:      `@trace(name:"x", 100)`,
+			wantErr: "1:18: annotation @trace: argument should be name:value",
+		},
+		{
+			name:    "duplicate name",
+			id:      `@trace(name:"x", name:"y")`,
+			wantErr: "1:18: annotation @trace: duplicate argument name",
+		},
+		{
+			name:    "not closed",
+			id:      `@trace(name:"x"`,
+			wantErr: "1:16: annotation @trace: missing ')'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := parseArgs(tt.id)
+			if tt.wantErr != "" {
+				if _, ok := err.(*AnnotationError); !ok || err.Error() != tt.wantErr {
+					t.Errorf("parseArgs() error = %v, want %v", err, tt.wantErr)
+				}
 				return
 			}
+			if err != nil {
+				t.Fatalf("parseArgs() error = %v", err)
+			}
+			
+			var got [][]string
+			for _, a := range args {
+				got = append(got, []string{a.name, a.value})
+				if !strings.HasPrefix(tt.id[a.offset:], a.name+":") {
+					t.Errorf("parseArgs() offset of %s = %d", a.name, a.offset)
+				}
+			}
 			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("getParamsFromID() got = %v, want %v", got, tt.want)
+				t.Errorf("parseArgs() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnnotationError(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "code.go")
+	os.WriteFile(file, []byte(`package main
+
+// hello
+// @trace(name:"hello", qps:)
+func hello() {}
+`), 0666)
+	
+	pkg, err := ParseDir(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	
+	stmt := map[string]StmtParams{
+		"@trace": {Stmts: []StmtParam{{Kind: AddFuncWithoutDepends, Stmt: []string{"fmt.Println()"}}}},
+	}
+	_, err = AddCode(Position(pkg, map[string]struct{}{"@trace": {}}), stmt, true)
+	want := file + ":4:29: annotation @trace: expected operand, found '}'"
+	if err == nil || err.Error() != want {
+		t.Errorf("AddCode() error = %v, want %v", err, want)
+	}
+}
+
+func Test_resolveArgs(t *testing.T) {
+	params := []Param{
+		{Name: "qps", Type: "int", Required: true},
+		{Name: "burst", Type: "int", Default: "10"},
+		{Name: "name", Default: `"ratelimit"`},
+		{Name: "timeout", Type: "time.Duration"},
+		{Name: "tag"},
+	}
+	tests := []struct {
+		name    string
+		id      string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "defaults",
+			id:   "@ratelimit(qps:100)",
+			want: []string{"var qps int = 100", "var burst int = 10", `name := "ratelimit"`, "var timeout time.Duration"},
+		},
+		{
+			name: "all arguments",
+			id:   `@ratelimit(timeout:3*time.Second, tag:"a", burst:1, qps:100, name:"x")`,
+			want: []string{"var qps int = 100", "var burst int = 1", `name := "x"`, "var timeout time.Duration = 3*time.Second", `tag := "a"`},
+		},
+		{
+			name:    "unknown argument",
+			id:      "@ratelimit(qps:100, rate:1)",
+			wantErr: "1:21: annotation @ratelimit: unknown argument rate, the valid arguments are qps, burst, name, timeout, tag",
+		},
+		{
+			name:    "missing required argument",
+			id:      "@ratelimit(burst:1)",
+			wantErr: "1:1: annotation @ratelimit: missing required argument qps",
+		},
+		{
+			name:    "wrong type",
+			id:      `@ratelimit(qps:"100")`,
+			wantErr: "1:12: annotation @ratelimit: argument qps: string value can not be used as int",
+		},
+	}
+	ij := injectDetail{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := parseArgs(tt.id)
+			if err != nil {
+				t.Fatalf("parseArgs() error = %v", err)
+			}
+			
+			args, err = resolveArgs(tt.id, args, params)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("resolveArgs() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveArgs() error = %v", err)
+			}
+			
+			if got := ij.getParamsDeclare(args); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolveArgs() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  []Param
+		wantErr string
+	}{
+		{
+			name:   "valid params",
+			params: []Param{{Name: "qps", Type: "int", Default: "100"}, {Name: "tags", Type: "[]string", Required: true}},
+		},
+		{
+			name:    "invalid name",
+			params:  []Param{{Name: "q-ps"}},
+			wantErr: "middleware @ratelimit: param[0] q-ps: name should be an identifier",
+		},
+		{
+			name:    "duplicate name",
+			params:  []Param{{Name: "qps"}, {Name: "qps"}},
+			wantErr: "middleware @ratelimit: param[1] qps: duplicate name",
+		},
+		{
+			name:    "required with default",
+			params:  []Param{{Name: "qps", Required: true, Default: "1"}},
+			wantErr: "middleware @ratelimit: param[0] qps: required param can not have default value",
+		},
+		{
+			name:    "wrong default type",
+			params:  []Param{{Name: "qps", Type: "int", Default: "1.5"}},
+			wantErr: "middleware @ratelimit: param[0] qps: default value: float value can not be used as int",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckParams("@ratelimit", tt.params)
+			if (err == nil && tt.wantErr != "") || (err != nil && err.Error() != tt.wantErr) {
+				t.Errorf("CheckParams() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_usedIdents(t *testing.T) {
+	sp := StmtParams{
+		Stmts:    []StmtParam{{Kind: AddDeferFuncStmt, Stmt: []string{`defer log.Println(name, x.path, "qps")`}}},
+		DeclStmt: []DeclParams{{VarName: "err", Stmt: []string{`fmt.Println(err)`}}},
+	}
+	
+	got := usedIdents(sp)
+	for _, name := range []string{"log", "name", "x", "fmt", "err"} {
+		if !got[name] {
+			t.Errorf("usedIdents() does not contain %s", name)
+		}
+	}
+	for _, name := range []string{"Println", "path", "qps"} {
+		if got[name] {
+			t.Errorf("usedIdents() contains %s", name)
+		}
+	}
+}
+
+func Test_findVarByType(t *testing.T) {
+	src := `package p
+
+import (
+	"bytes"
+	"context"
+	nethttp "net/http"
+	"time"
+)
+
+type Server struct{}
+
+func f(_ context.Context, c context.Context, r *nethttp.Request) {
+	var a int
+	client := &nethttp.Client{}
+	var s = Server{}
+	n := new(bytes.Buffer)
+	x, y := 1, 2
+	timer := time.NewTimer(time.Second)
+	_, _, _, _, _, _, _ = a, client, s, n, x, y, timer
+}
+`
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd := f.Decls[len(f.Decls)-1].(*ast.FuncDecl)
+	info := &types.Info{Defs: make(map[*ast.Ident]types.Object)}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	if _, err := conf.Check("p", fset, []*ast.File{f}, info); err != nil {
+		t.Fatal(err)
+	}
+	
+	// syntactic is the result without info, which only knows the declared types and the composite literals.
+	tests := []struct {
+		typ       string
+		name      string
+		isParam   bool
+		syntactic string
+	}{
+		{typ: "context.Context", name: "c", isParam: true, syntactic: "c"},
+		{typ: "* http.Request", name: "r", isParam: true},
+		{typ: "int", name: "a", syntactic: "a"},
+		{typ: "*http.Client", name: "client"},
+		{typ: "Server", name: "s", syntactic: "s"},
+		{typ: "*bytes.Buffer", name: "n", syntactic: "n"},
+		{typ: "*time.Timer", name: "timer"},
+		{typ: "string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typ, func(t *testing.T) {
+			name, isParam := findVarByType(fd.Type.Params, fd.Body.List, normalizeType(tt.typ), info)
+			if name != tt.name || isParam != tt.isParam {
+				t.Errorf("findVarByType() = %v, %v, want %v, %v", name, isParam, tt.name, tt.isParam)
+			}
+			name, _ = findVarByType(fd.Type.Params, fd.Body.List, normalizeType(tt.typ), nil)
+			if name != tt.syntactic {
+				t.Errorf("findVarByType() without info = %v, want %v", name, tt.syntactic)
+			}
+		})
+	}
+}
+
+func Test_renameIdents(t *testing.T) {
+	renames := map[string]string{"name": "name1", "span": "span1"}
+	tests := []struct {
+		code string
+		want string
+	}{
+		{code: `span := trace.Start(name)`, want: `span1 := trace.Start(name1)`},
+		{code: `fmt.Println(x.name, "name")`, want: `fmt.Println(x.name, "name")`},
+		{code: `p := Point{name: name}`, want: `p := Point{name: name1}`},
+		{code: `s := list[name:]`, want: `s := list[name1:]`},
+		{code: `defer func() { fmt.Println(span) }()`, want: `defer func() { fmt.Println(span1) }()`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := renameIdents(tt.code, renames); got != tt.want {
+				t.Errorf("renameIdents() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_declaredNames(t *testing.T) {
+	params := map[string]bool{"ctx": true}
+	tests := []struct {
+		code string
+		want []string
+	}{
+		{code: `span := "x"`, want: []string{"span"}},
+		{code: `var a, b int`, want: []string{"a", "b"}},
+		{code: `ctx, cancel := context.WithCancel(ctx)`, want: []string{"cancel"}},
+		{code: `__ctx__, cancel := context.WithTimeout(__ctx__, time.Second)`, want: []string{"cancel"}},
+		{code: `ctx, _ := context.WithCancel(ctx)`},
+		{code: `span = "x"`},
+		{code: `fmt.Println(span)`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := declaredNames(tt.code, params); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("declaredNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_receiverName(t *testing.T) {
+	src := `package p
+
+func (c *Cache[K, V]) Get() {}
+func (c Cache[K, V]) Len() {}
+func (b *Box[T]) Value() {}
+func (b (Box[T])) Set() {}
+func (s *Service) Create() {}
+func (s Service) List() {}
+func Map[T any]() {}
+`
+	f, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	
+	want := []string{"Cache", "Cache", "Box", "Box", "Service", "Service", noReceiver}
+	for i, decl := range f.Decls {
+		fd := decl.(*ast.FuncDecl)
+		t.Run(fd.Name.Name, func(t *testing.T) {
+			if got := receiverName(fd); got != want[i] {
+				t.Errorf("receiverName() = %v, want %v", got, want[i])
+			}
+			if !isEqual(fd, fun{owner: want[i], name: fd.Name.Name}) {
+				t.Errorf("isEqual() = false, want true")
+			}
+		})
+	}
+}
+
+func Test_inheritance_match(t *testing.T) {
+	tests := []struct {
+		in   *inheritance
+		name string
+		want bool
+	}{
+		{in: &inheritance{}, name: "get", want: true},
+		{in: &inheritance{exported: true}, name: "get"},
+		{in: &inheritance{exported: true}, name: "Get", want: true},
+		{in: &inheritance{names: []string{"Find*", "Get"}}, name: "FindAll", want: true},
+		{in: &inheritance{names: []string{"Find*", "Get"}}, name: "GetAll"},
+		{in: &inheritance{names: []string{"[Find"}}, name: "Find"},
+		{name: "Get"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.in.match(tt.name); got != tt.want {
+				t.Errorf("match() = %v, want %v", got, tt.want)
 			}
 		})
 	}
@@ -34,12 +34,21 @@ type OperationKind int
 // the same variable. For example, the previous stmt bind err is valid. If there has other stmts binding
 // str(a new string variable). We can't find different variables at the same time, so we can not insert stmt right.
 //
-// At last, Packs save the import data. Maybe user has import the same package, so named a unique name
+// Packs save the import data. Maybe user has import the same package, so named a unique name
 // for avoid repeat is a good idea.
+//
+// Order decides the nesting when a function has several aspects. The aspect with smaller
+// Order is outer: its before-code runs first and its defer-code runs last. Aspects with the same
+// Order nest by the order of ids in comment, the first one is the outermost.
+//
+// At last, Params declares the arguments which the AOP id accepts, like `qps` in `@ratelimit(qps:100)`.
+// If Params is empty, any arguments are accepted and declared verbatim.
 type StmtParams struct {
 	DeclStmt []DeclParams
 	Stmts    []StmtParam
 	Packs    []Pack
+	Order    int
+	Params   []Param
 }
 
 type Pack struct {
@@ -47,6 +56,18 @@ type Pack struct {
 	Path string
 }
 
+// Param declares an argument of AOP id.
+// Type is the Go type of the declared variable, e.g. `int` or `time.Duration`. The variable is
+// declared by `:=` if Type is empty.
+// Default is the Go source of value used when the argument is missing, e.g. `100` or `3*time.Second`.
+// Required argument can not be missing, so it has no Default.
+type Param struct {
+	Name     string
+	Type     string
+	Default  string
+	Required bool
+}
+
 // DeclParams store stmt insert behind specify variable
 type DeclParams struct {
 	VarName  string // VarName is the variable name ,like 'x := 1', the x is var name.
@@ -58,11 +79,22 @@ type DeclParams struct {
 // Kind decides to how and where to insert stmt.
 // Stmt is the string of stmt, use parseStmt before use these.
 // Depends are the dependence conditions
+// DependType binds the variable by type instead of name, e.g. `context.Context` or `*http.Request`,
+// the code gets the variable name by `__varName__`. More detail please reference `bindDependType`.
+// Source is where the stmt is declared, it is used for error message.
 type StmtParam struct {
 	Kind        OperationKind
 	Stmt        []string
 	Depends     []string
 	FuncDepends []string
+	DependType  string
+	Source      Source
+}
+
+// Source is a position in config file, like aop.toml:12.
+type Source struct {
+	File string
+	Line int
 }
 
 type StmtDepend interface {
@@ -55,7 +55,7 @@ package case_01
 // If user use inject id, please add `"github.com/runways/goAOP/inject"` in Pack block.
 
 type FirstStruct struct {
-	name string `json:"name"`
+	name string
 	Age  int    `json:"age"`
 }
 
@@ -6,7 +6,7 @@ import (
 )
 
 type FirstStruct struct {
-	name string `json:"name"`
+	name string
 	Age  int    `json:"age"`
 }
 
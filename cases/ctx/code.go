@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// There are some examples of `__ctx__` and `add-around-ctx`. The woven code is saved in `code.golden`.
+// `__ctx__` expands to the context parameter, `r.Context()` of the request parameter, or
+// `context.Background()`. The code of `add-around-ctx` replaces the context which the body gets,
+// and `__ctx__` in the other code of that aspect is the new context.
+
+// Get
+// @timeout
+func Get(ctx context.Context, id int) {
+	fmt.Println(ctx, id)
+}
+
+// Serve
+// @timeout
+func Serve(w http.ResponseWriter, r *http.Request) {
+	fmt.Println(r.Context())
+}
+
+// Run
+// @timeout
+func Run() {
+	fmt.Println("run")
+}
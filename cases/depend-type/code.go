@@ -0,0 +1,53 @@
+package client
+
+import (
+	"context"
+	stdctx "context"
+	"fmt"
+	"net/http"
+)
+
+// There are some examples of the depend by type. The woven code is saved in `code.golden`.
+// The code binds the first variable of the type, `__varName__` is replaced by its name. The types are
+// resolved by type checking, so the local variable assigned by a call and the aliased import are bound too.
+
+// Fetch
+// @deadline
+func Fetch(c context.Context, url string) {
+	fmt.Println(c, url)
+}
+
+// Post
+// @deadline
+// @timeout
+func Post(reqCtx context.Context, url string) {
+	client := &http.Client{}
+	fmt.Println(reqCtx, client.Timeout)
+}
+
+// Handler
+// @handler
+func Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		fmt.Println(req.URL)
+	}
+}
+
+// Run
+// @deadline
+// @timeout
+func Run(r *http.Request) {
+	reqCtx := r.Context()
+	client := newClient()
+	fmt.Println(reqCtx, client.Timeout)
+}
+
+// Serve
+// @deadline
+func Serve(ctx stdctx.Context) {
+	fmt.Println(ctx)
+}
+
+func newClient() *http.Client {
+	return &http.Client{}
+}
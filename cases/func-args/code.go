@@ -0,0 +1,23 @@
+package auth
+
+import "fmt"
+
+// There are some examples of `__args__`. The woven code is saved in `code.golden`.
+// `__args__` expands to the name/value pairs of parameters, includes the receiver and the
+// variadic parameter. The parameters marked by `//goaop:sensitive` are skipped.
+
+type Service struct{}
+
+// Login
+// @log
+//
+//goaop:sensitive password
+func (s *Service) Login(user, password string, _ int, opts ...string) {
+	fmt.Println("Login")
+}
+
+// Logout
+// @log
+func Logout() {
+	fmt.Println("Logout")
+}
@@ -0,0 +1,48 @@
+package cache
+
+import "fmt"
+
+// There are some examples of generic functions and methods on generic types. The woven code is saved in
+// `code.golden`. The type parameters are in `{{.TypeParams}}`, and `{{.TypeArgs}}` writes them as `[K, V]`.
+
+type Cache[K comparable, V any] struct {
+	items map[K]V
+}
+
+type Box[T any] struct {
+	value T
+}
+
+// Get
+// @trace
+func (c *Cache[K, V]) Get(key K) V {
+	return c.items[key]
+}
+
+// Len
+// @trace
+func (c Cache[K, _]) Len() int {
+	return len(c.items)
+}
+
+// Value
+// @trace
+func (b *Box[T]) Value() T {
+	return b.value
+}
+
+// Map
+// @trace
+func Map[T, R any](s []T, f func(T) R) []R {
+	result := make([]R, 0, len(s))
+	for _, v := range s {
+		result = append(result, f(v))
+	}
+	return result
+}
+
+// Print
+// @trace
+func Print(v any) {
+	fmt.Println(v)
+}
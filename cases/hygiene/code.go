@@ -0,0 +1,34 @@
+package orders
+
+import (
+	"context"
+	"fmt"
+)
+
+// There are some examples of the hygienic injected variables. The woven code is saved in `code.golden`.
+// The injected variables which collide with the function are renamed, like `name1` and `span1`, the
+// references in the code of aspect are renamed too. The unused arguments of
+// `add-func-without-depends-with-injection` are assigned to `_`. The parameter `ctx` assigned by
+// `__ctx__, cancel := ...` is kept, but `cancel` declared by the body is renamed.
+
+// Create
+// @trace(name:"create")
+// @span
+// @audit(user:"admin", level:1)
+func Create(name string) error {
+	span := "body"
+	fmt.Println(name, span)
+	return nil
+}
+
+// Cancel
+// @timeout
+func Cancel(ctx context.Context) error {
+	cancel := func() {}
+	defer cancel()
+	return ctx.Err()
+}
+
+type Point struct {
+	name string
+}
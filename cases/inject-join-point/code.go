@@ -0,0 +1,17 @@
+package orders
+
+import "fmt"
+
+// There are some examples of `@inject` with type `inject.JoinPoint`. The woven code is saved in `code.golden`.
+// @trace declares the param `jp` with type `inject.JoinPoint` and default value `@inject`, so every function
+// gets the metadata without writing `jp:@inject` in comment.
+
+type Service struct{}
+
+// Create
+// @trace(span:"create")
+// @log
+func (s *Service) Create(id int) error {
+	fmt.Println("Create")
+	return nil
+}
@@ -3,7 +3,7 @@ package insert_code_behind_variable
 import "fmt"
 
 type FirstStruct struct {
-	name string `json:"name"`
+	name string
 	Age  int    `json:"age"`
 }
 
@@ -0,0 +1,28 @@
+package orders
+
+import "fmt"
+
+// There are some examples of the snippet templates. The woven code is saved in `code.golden`.
+// Every snippet can use the metadata of function, like `{{.FullName}}`, `{{.Params}}` and
+// the annotation arguments `{{.Args.span}}`.
+
+type Service struct{}
+
+// Create
+// @trace(span:"create")
+func (s *Service) Create(id int, name string) (err error) {
+	fmt.Println("Create")
+	return nil
+}
+
+// Get
+// @trace
+func (s Service) Get(id int) string {
+	return "Get"
+}
+
+// List
+// @trace
+func List() {
+	fmt.Println("List")
+}
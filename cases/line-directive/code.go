@@ -0,0 +1,21 @@
+package orders
+
+import "fmt"
+
+// There are some examples of `//line` directives. The woven code is saved in `code.golden`.
+// The inserted code is attributed to the Source of its Stmt, or the middleware id and Stmt if
+// the Stmt has no Source, the original code keeps its line.
+
+// Create
+// @trace
+func Create(id int) {
+	fmt.Println(id)
+	if id < 0 {
+		panic("negative id")
+	}
+}
+
+// Delete has no aspect, it keeps its line since the directive before the body of Create.
+func Delete(id int) {
+	panic(id)
+}
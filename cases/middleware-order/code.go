@@ -0,0 +1,23 @@
+package middleware_order
+
+import "fmt"
+
+// There are some examples of nesting aspects. The woven code is saved in `code.golden`.
+// 1. `invokeFirstFunction` has two aspects with the same order, so the first one in comment
+// is the outermost. Its before-code runs first, and its defer-code runs last.
+// 2. `invokeSecondFunction` has three aspects, @middleware-recover has the smallest order,
+// so it is the outermost one although it is the last one in comment.
+
+// invokeFirstFunction
+// @middleware-log
+// @middleware-trace
+func invokeFirstFunction() {
+	fmt.Println("invokeFirstFunction")
+}
+
+// invokeSecondFunction
+// @middleware-trace @middleware-log
+// @middleware-recover
+func invokeSecondFunction() {
+	fmt.Println("invokeSecondFunction")
+}
@@ -0,0 +1,40 @@
+// Package orders is an example of the AOP ids annotated on package. The woven code is saved in `code.golden`.
+// The ids in the package doc apply to all the functions and methods, and the ones of `//goaop:package`
+// apply to the functions and methods which pass the `//goaop:filter` in the same comment group.
+//
+// @log
+package orders
+
+import "fmt"
+
+//goaop:package @trace(span:"orders")
+//goaop:filter exported name=Get*
+
+type Service struct{}
+
+// Create is logged only.
+func (s *Service) Create(id int) {
+	fmt.Println("Create", id)
+}
+
+// GetByID is logged and traced.
+func (s *Service) GetByID(id int) {
+	fmt.Println("GetByID", id)
+}
+
+// getCache is logged only, since it is not exported.
+func getCache(id int) {
+	fmt.Println("getCache", id)
+}
+
+// GetAll overrides the arguments of trace.
+// @trace(span:"all")
+func GetAll() {
+	fmt.Println("GetAll")
+}
+
+// Ping opts out of all the ids of package.
+//goaop:skip
+func Ping() {
+	fmt.Println("Ping")
+}
@@ -0,0 +1,24 @@
+package ratelimit
+
+import (
+	"fmt"
+	"time"
+)
+
+// There are some examples of the declared params. The woven code is saved in `code.golden`.
+// @ratelimit declares `qps` is required, `burst` and `timeout` have default values, so they are
+// declared with their types although the comment does not give them.
+
+var _ = time.Second
+
+// query
+// @ratelimit(qps:100)
+func query() {
+	fmt.Println("query")
+}
+
+// update
+// @ratelimit(qps:10, timeout:time.Second)
+func update() {
+	fmt.Println("update")
+}
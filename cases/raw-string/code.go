@@ -0,0 +1,19 @@
+package report
+
+import "fmt"
+
+// There are some examples of the code with multi-line raw strings. The woven code is saved in `code.golden`.
+// The inserted code is indented like the body, but the lines inside a raw string are kept as they are,
+// so the string prints the same.
+
+// Print
+// @banner
+func Print(name string) {
+	if name != "" {
+		fmt.Println(name)
+	}
+}
+
+// One
+// @banner
+func One() { fmt.Println("one") }
@@ -0,0 +1,26 @@
+package timer
+
+import (
+	"fmt"
+	"time"
+)
+
+// There are some examples of the annotation arguments used by every kind. The woven code is saved
+// in `code.golden`. `name` is declared in the head of function, so the defer code and the code bellow
+// `err` can use it. `unused` is not used by any code, so it is not declared.
+
+var _ = time.Second
+
+// checkout
+// @timer(name:"checkout", unused:1)
+func checkout() error {
+	err := fmt.Errorf("checkout")
+	return err
+}
+
+// refund
+// @timer
+func refund() error {
+	err := fmt.Errorf("refund")
+	return err
+}
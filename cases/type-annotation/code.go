@@ -0,0 +1,53 @@
+package orders
+
+import "fmt"
+
+// There are some examples of the AOP ids annotated on types. The woven code is saved in `code.golden`.
+// The ids of a type apply to all its methods, `//goaop:filter` limits the methods, and `//goaop:skip`
+// opts a method out.
+
+// Service traces its exported methods.
+// @trace(span:"service")
+//goaop:filter exported
+type Service struct{}
+
+// Create inherits the trace from Service.
+func (s *Service) Create(id int) {
+	fmt.Println("Create", id)
+}
+
+// Get overrides the arguments of trace, and adds log.
+// @trace(span:"get") @log
+func (s Service) Get(id int) {
+	fmt.Println("Get", id)
+}
+
+// Delete opts out of the trace.
+//goaop:skip @trace
+func (s *Service) Delete(id int) {
+	fmt.Println("Delete", id)
+}
+
+// validate is not exported, so it has no aspect.
+func (s *Service) validate(id int) bool {
+	return id > 0
+}
+
+type (
+	// Repo logs the methods named `Find*`.
+	// @log
+	//goaop:filter name=Find*
+	Repo[T any] struct {
+		items []T
+	}
+)
+
+// FindAll inherits the log from Repo.
+func (r *Repo[T]) FindAll() []T {
+	return r.items
+}
+
+// Save has no aspect.
+func (r *Repo[T]) Save(item T) {
+	r.items = append(r.items, item)
+}
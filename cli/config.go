@@ -1,201 +1,90 @@
 package main
 
 import (
-	"github.com/BurntSushi/toml"
-	"github.com/runways/goAOP/aops"
-	"strings"
+	"flag"
+	"fmt"
+	"github.com/runways/goAOP/aops/config"
+	"io"
+	"os"
 )
 
-type Config struct {
-	Include    []string     `toml:"include"`
-	MidWare    []middleWare `toml:"middleware"`
-	MidWareMap map[string]aops.StmtParams
-}
-
-type middleWare struct {
-	ID      string `toml:"id"`
-	Stmt    []Stmt `toml:"Stmt"`
-	Package []pack `toml:"package"`
-}
+const configUsage = `Usage:
+  aop config validate <file>...   validate config files against the JSON Schema and parse their code,
+                                  includes are validated too
+  aop config schema [-o file]     output the JSON Schema of config file
+`
 
-type pack struct {
-	Name string `toml:"name"`
-	Path string `toml:"path"`
-}
+// configCommand run `aop config` sub commands, args are the arguments after `config`.
+// It returns the exit code.
+func configCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, configUsage)
+		return 2
+	}
 
-// Stmt save all the code will injection to source code
-// ID is the middleware id, should match with comment in function.
-// Kind is the middleware type.Valid values declare in the `aops/const.go`.
-// Code is a string array, save the code will injection to source code.
-// Depend is a string array, save the injection conditions. Now only support
-// signal variable. No need type variable type.
-type Stmt struct {
-	//ID     string   `toml:"id"`
-	Kind      string   `toml:"kind"`
-	Code      []string `toml:"code,omitempty"`
-	Depend    []string `toml:"depend,omitempty"`
-	FunDepend []string `toml:"funDepend,omitempty"`
+	switch args[0] {
+	case "validate":
+		return validateCommand(args[1:], stdout, stderr)
+	case "schema":
+		return schemaCommand(args[1:], stdout, stderr)
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n%s", args[0], configUsage)
+		return 2
+	}
 }
 
-func parseConfigFromFile(file string) (c Config, err error) {
-	_, err = toml.DecodeFile(file, &c)
-	if err != nil {
-		return
+// validateCommand validate every file against the schema of `config.Schema`, then parse it, so the code
+// is checked too. Output all the mistakes, return 1 if any file is invalid.
+func validateCommand(files []string, stdout, stderr io.Writer) int {
+	if len(files) == 0 {
+		fmt.Fprint(stderr, configUsage)
+		return 2
 	}
-	
-	mwm := make(map[string]aops.StmtParams, len(c.MidWare))
-	for _, m := range c.MidWare {
-		var p []aops.Pack
-		var stmtBlock []aops.StmtParam
-		for _, _p := range m.Package {
-			p = append(p, aops.Pack{
-				Name: strings.TrimSpace(_p.Name),
-				Path: strings.TrimSpace(_p.Path),
-			})
-		}
-		
-		for _, s := range m.Stmt {
-			switch strings.TrimSpace(strings.ToLower(s.Kind)) {
-			case aops.AddFuncWithoutDependsStr:
-				stmtBlock = append(stmtBlock, aops.StmtParam{
-					Kind:        aops.AddFuncWithoutDepends,
-					Stmt:        s.Code,
-					Depends:     nil,
-					FuncDepends: s.FunDepend,
-				})
-			case aops.AddFuncWithVarStmtStr:
-				stmtBlock = append(stmtBlock, aops.StmtParam{
-					Kind:        aops.AddFuncWithVarStmt,
-					Stmt:        s.Code,
-					Depends:     s.Depend,
-					FuncDepends: s.FunDepend,
-				})
-			case aops.AddDeferFuncStmtStr:
-				stmtBlock = append(stmtBlock, aops.StmtParam{
-					Kind:        aops.AddDeferFuncStmt,
-					Stmt:        s.Code,
-					Depends:     nil,
-					FuncDepends: s.FunDepend,
-				})
-			case aops.AddDeferFuncWithVarStmtStr:
-				stmtBlock = append(stmtBlock, aops.StmtParam{
-					Kind:        aops.AddDeferFuncWithVarStmt,
-					Stmt:        s.Code,
-					Depends:     s.Depend,
-					FuncDepends: s.FunDepend,
-				})
-			case aops.AddReturnFuncWithoutVarStmtStr:
-				stmtBlock = append(stmtBlock, aops.StmtParam{
-					Kind:        aops.AddReturnFuncWithoutVarStmt,
-					Stmt:        s.Code,
-					Depends:     nil,
-					FuncDepends: s.FunDepend,
-				})
-			case aops.AddReturnFuncWithVarStmtStr:
-				stmtBlock = append(stmtBlock, aops.StmtParam{
-					Kind:        aops.AddReturnFuncWithVarStmt,
-					Stmt:        s.Code,
-					Depends:     s.Depend,
-					FuncDepends: s.FunDepend,
-				})
-			}
+
+	code := 0
+	for _, f := range files {
+		if err := config.ValidateSchema(f); err != nil {
+			fmt.Fprintln(stderr, err)
+			code = 1
+			continue
 		}
-		
-		mwm[m.ID] = aops.StmtParams{
-			Stmts: stmtBlock,
-			Packs: p,
+		if _, err := config.Parse(f); err != nil {
+			fmt.Fprintln(stderr, err)
+			code = 1
+			continue
 		}
+
+		fmt.Fprintf(stdout, "%s: OK\n", f)
 	}
-	
-	c.MidWareMap = mwm
-	return
+
+	return code
 }
 
-func parseConfig(file string) (c Config, err error) {
-	_, err = toml.DecodeFile(file, &c)
+// schemaCommand output the schema to stdout or the file specified by -o.
+func schemaCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("schema", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	output := fs.String("o", "", "The file to save schema, default is stdout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	schema, err := config.Schema()
 	if err != nil {
-		return
+		fmt.Fprintln(stderr, err)
+		return 1
 	}
-	
-	mwm := make(map[string]aops.StmtParams)
-	if len(c.Include) > 0 {
-		
-		for _, i := range c.Include {
-			c, err := parseConfigFromFile(i)
-			if err != nil {
-				return c, err
-			}
-			for key, val := range c.MidWareMap {
-				mwm[key] = val
-			}
-		}
-		
+	schema = append(schema, '\n')
+
+	if *output == "" {
+		stdout.Write(schema)
+		return 0
 	}
-	
-	//mwm := make(map[string]aops.StmtParams, len(c.MidWare))
-	for _, m := range c.MidWare {
-		var p []aops.Pack
-		var stmtBlock []aops.StmtParam
-		for _, _p := range m.Package {
-			p = append(p, aops.Pack{
-				Name: strings.TrimSpace(_p.Name),
-				Path: strings.TrimSpace(_p.Path),
-			})
-		}
-		
-		for _, s := range m.Stmt {
-			switch strings.TrimSpace(strings.ToLower(s.Kind)) {
-			case aops.AddFuncWithoutDependsStr:
-				stmtBlock = append(stmtBlock, aops.StmtParam{
-					Kind:        aops.AddFuncWithoutDepends,
-					Stmt:        s.Code,
-					Depends:     nil,
-					FuncDepends: s.FunDepend,
-				})
-			case aops.AddFuncWithVarStmtStr:
-				stmtBlock = append(stmtBlock, aops.StmtParam{
-					Kind:        aops.AddFuncWithVarStmt,
-					Stmt:        s.Code,
-					Depends:     s.Depend,
-					FuncDepends: s.FunDepend,
-				})
-			case aops.AddDeferFuncStmtStr:
-				stmtBlock = append(stmtBlock, aops.StmtParam{
-					Kind:        aops.AddDeferFuncStmt,
-					Stmt:        s.Code,
-					Depends:     nil,
-					FuncDepends: s.FunDepend,
-				})
-			case aops.AddDeferFuncWithVarStmtStr:
-				stmtBlock = append(stmtBlock, aops.StmtParam{
-					Kind:        aops.AddDeferFuncWithVarStmt,
-					Stmt:        s.Code,
-					Depends:     s.Depend,
-					FuncDepends: s.FunDepend,
-				})
-			case aops.AddReturnFuncWithoutVarStmtStr:
-				stmtBlock = append(stmtBlock, aops.StmtParam{
-					Kind:        aops.AddReturnFuncWithoutVarStmt,
-					Stmt:        s.Code,
-					Depends:     nil,
-					FuncDepends: s.FunDepend,
-				})
-			case aops.AddReturnFuncWithVarStmtStr:
-				stmtBlock = append(stmtBlock, aops.StmtParam{
-					Kind:        aops.AddReturnFuncWithVarStmt,
-					Stmt:        s.Code,
-					Depends:     s.Depend,
-					FuncDepends: s.FunDepend,
-				})
-			}
-		}
-		
-		mwm[m.ID] = aops.StmtParams{
-			Stmts: stmtBlock,
-			Packs: p,
-		}
+
+	if err := os.WriteFile(*output, schema, 0666); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
 	}
-	
-	c.MidWareMap = mwm
-	return
+
+	return 0
 }
@@ -1,163 +1,93 @@
 package main
 
 import (
-	"fmt"
-	"github.com/runways/goAOP/aops"
+	"bytes"
 	"os"
-	"reflect"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
-func Test_parseConfig(t *testing.T) {
-
-	subFile, _ := os.CreateTemp("", "")
-	os.WriteFile(subFile.Name(), []byte(`
-[[middleware]]
-    id="@middleware-b"
-	[[middleware.Stmt]]
-	kind="add-return-func-with-var"
-    code=["""func(){
-                log.Println("before")
-            }()"""]
-	depend=["err"]
-	[[middleware.Stmt]]
-	kind="add-return-func-without-var"
-    code =["""func(){
-                log.Println("before")
-            }()"""]
-	depend=["str"]
-`), 0777)
-
-	conf := fmt.Sprintf(`
-include=["%s"]
+func Test_configCommand(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.toml")
+	os.WriteFile(valid, []byte(`
 [[middleware]]
-    id="@middleware-a"
-	[[middleware.Stmt]]
-	kind="add-func-without-depends"
-    code=["""func(){
-                log.Println("before")
-            }()"""]
-	depend=["err"]
-	[[middleware.Stmt]]
-	kind="add-func-with-var-depend"
-    code =["""func(){
-                log.Println("before")
-            }()"""]
-	depend=["str"]
-    [[middleware.package]]
-        name = "log"
-        path = """"github.com/sirupsen/logrus""""
-
-`, subFile.Name())
-	f, _ := os.CreateTemp("", "")
-	os.WriteFile(f.Name(), []byte(conf), 0777)
+    id="@a"
+    [[middleware.Stmt]]
+    kind="add-defer-func"
+    code=["defer fmt.Println()"]
+`), 0666)
+	invalid := filepath.Join(dir, "invalid.yaml")
+	os.WriteFile(invalid, []byte(`
+middleware:
+  - id: "@a"
+    Stmt:
+      - kind: add-defer-func
+        code: ["defer fmt.Println("]
+`), 0666)
+	schema := filepath.Join(dir, "schema.json")
+	os.WriteFile(schema, []byte(`{
+  "middleware": [
+    {
+      "id": "@a",
+      "Stmt": [
+        {"kind": "add-defer-func", "code": ["defer fmt.Println()"], "deps": ["x"]}
+      ]
+    }
+  ]
+}`), 0666)
 
-	type args struct {
-		file string
-	}
 	tests := []struct {
-		name    string
-		args    args
-		wantC   Config
-		wantErr bool
+		name       string
+		args       []string
+		wantCode   int
+		wantStdout string
+		wantStderr string
 	}{
 		{
-			name: "Normal parse",
-			args: struct{ file string }{file: f.Name()},
-			wantC: Config{
-				Include: []string{subFile.Name()},
-				MidWare: []middleWare{
-					{
-						ID: "@middleware-a",
-						Package: []pack{
-							{Name: "log", Path: "\"github.com/sirupsen/logrus\""},
-						},
-						Stmt: []Stmt{
-							{
-								Kind: "add-func-without-depends",
-								Code: []string{`func(){
-                log.Println("before")
-            }()`},
-								Depend: []string{"err"},
-							},
-							{
-								Kind: "add-func-with-var-depend",
-								Code: []string{`func(){
-                log.Println("before")
-            }()`},
-								Depend: []string{"str"},
-							},
-						},
-					},
-				},
-				MidWareMap: map[string]aops.StmtParams{
-					"@middleware-b": aops.StmtParams{
-						DeclStmt: nil,
-						Stmts: []aops.StmtParam{
-							aops.StmtParam{
-								Kind: aops.AddReturnFuncWithVarStmt,
-								Stmt: []string{
-									`func(){
-                log.Println("before")
-            }()`,
-								},
-								Depends: []string{"err"},
-							},
-							aops.StmtParam{
-								Kind: aops.AddReturnFuncWithoutVarStmt,
-								Stmt: []string{
-									`func(){
-                log.Println("before")
-            }()`,
-								},
-								Depends: nil,
-							},
-						},
-						Packs: nil,
-					},
-					"@middleware-a": aops.StmtParams{
-						DeclStmt: nil,
-						Stmts: []aops.StmtParam{
-							aops.StmtParam{
-								Kind: aops.AddFuncWithoutDepends,
-								Stmt: []string{
-									`func(){
-                log.Println("before")
-            }()`,
-								},
-								Depends: nil,
-							},
-							aops.StmtParam{
-								Kind: aops.AddFuncWithVarStmt,
-								Stmt: []string{
-									`func(){
-                log.Println("before")
-            }()`,
-								},
-								Depends: []string{"str"},
-							},
-						},
-						Packs: []aops.Pack{
-							aops.Pack{
-								Name: "log",
-								Path: "\"github.com/sirupsen/logrus\"",
-							},
-						},
-					},
-				},
-			},
-			wantErr: false,
+			name:       "valid file",
+			args:       []string{"validate", valid},
+			wantCode:   0,
+			wantStdout: valid + ": OK",
+		},
+		{
+			name:       "invalid file",
+			args:       []string{"validate", valid, invalid},
+			wantCode:   1,
+			wantStdout: valid + ": OK",
+			wantStderr: invalid + ":5: middleware @a: Stmt[0] code[0]:",
+		},
+		{
+			name:       "schema mismatch",
+			args:       []string{"validate", schema},
+			wantCode:   1,
+			wantStderr: schema + ":6: schema: /middleware/0/Stmt/0/deps: is an unknown key",
+		},
+		{
+			name:       "schema",
+			args:       []string{"schema"},
+			wantCode:   0,
+			wantStdout: `"add-func-with-var-depend"`,
+		},
+		{
+			name:       "unknown command",
+			args:       []string{"check"},
+			wantCode:   2,
+			wantStderr: "Usage",
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotC, err := parseConfig(tt.args.file)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("parseConfig() error = %v, wantErr %v", err, tt.wantErr)
-				return
+			var stdout, stderr bytes.Buffer
+			if got := configCommand(tt.args, &stdout, &stderr); got != tt.wantCode {
+				t.Errorf("configCommand() = %v, want %v, stderr: %s", got, tt.wantCode, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tt.wantStdout) {
+				t.Errorf("configCommand() stdout = %v, want %v", stdout.String(), tt.wantStdout)
 			}
-			if !reflect.DeepEqual(gotC, tt.wantC) {
-				t.Errorf("parseConfig() gotC = %v, want %v", gotC, tt.wantC)
+			if !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf("configCommand() stderr = %v, want %v", stderr.String(), tt.wantStderr)
 			}
 		})
 	}
@@ -6,7 +6,11 @@ var (
 	// main operation modes
 	dir     = flag.String("dir", "", "The source code file dir path")
 	replace = flag.Bool("replace", true, "Replace source code file or not")
-	conf    = flag.String("config", "aop.toml", "The runtime config")
+	conf    = flag.String("config", "aop.toml", "The runtime config, toml, yaml or json")
+	// typeCheck refuses to write the files which can not compile
+	typeCheck = flag.Bool("check", false, "Type-check the woven packages, the files with errors are not written")
+	// lineDirectives keeps the line numbers of original code in stack traces and coverage
+	lineDirectives = flag.Bool("line", false, "Emit //line directives around the inserted code")
 	// debug operation mode
 	debug = flag.Bool("debug", false, "Enable / Disable debug output")
 )
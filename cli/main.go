@@ -4,7 +4,9 @@ import (
 	"flag"
 	"fmt"
 	"github.com/runways/goAOP/aops"
+	"github.com/runways/goAOP/aops/config"
 	"os"
+	"sort"
 )
 
 func main() {
@@ -15,11 +17,15 @@ func main() {
 		}
 	}()
 	
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(configCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	
 	flag.Parse()
 	
 	check()
 	
-	c, err := parseConfig(*conf)
+	c, err := config.Parse(*conf)
 	if err != nil {
 		fmt.Println(err.Error())
 		os.Exit(-1)
@@ -36,21 +42,24 @@ func main() {
 		os.Exit(-1)
 	}
 	
-	aopMap := make(map[string]struct{})
-	for name := range c.MidWareMap {
-		aopMap[name] = struct{}{}
+	pkgMap := aops.Position(pkgs, config.Ids(c.MidWareMap))
+	
+	// output the files by order, make the logs same between runs.
+	files := make([]string, 0, len(pkgMap))
+	for file := range pkgMap {
+		files = append(files, file)
 	}
+	sort.Strings(files)
 	
-	pkgMap := aops.Position(pkgs, aopMap)
 	if *debug {
 		fmt.Println("These files will be modify:")
-		for key := range pkgMap {
-			fmt.Println(key)
+		for _, file := range files {
+			fmt.Println(file)
 		}
 		fmt.Println("=======>")
 	}
 	
-	modify, err := aops.AddCode(pkgMap, c.MidWareMap, *replace)
+	modify, err := aops.AddCodeWithOptions(pkgMap, c.MidWareMap, *replace, aops.Options{TypeCheck: *typeCheck, LineDirectives: *lineDirectives})
 	if err != nil {
 		fmt.Println("FAILED")
 		fmt.Println(err.Error())
@@ -59,8 +68,8 @@ func main() {
 	
 	if *debug {
 		fmt.Println("AOP result:")
-		for file, change := range pkgMap {
-			fmt.Printf("%s : %+v \n", file, change)
+		for _, file := range files {
+			fmt.Printf("%s : %+v \n", file, pkgMap[file])
 		}
 		fmt.Println("=======>")
 	}
@@ -83,6 +92,6 @@ func check() {
 	
 }
 
-func outputConfig(c Config) {
+func outputConfig(c config.Config) {
 	fmt.Printf("%+v \n", c.MidWare)
 }
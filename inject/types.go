@@ -1,6 +1,48 @@
 package inject
 
+import "time"
+
 type AOPLabel struct {
 	Name  string
 	Owner string
 }
+
+// Arg is a parameter of function and its value, the placeholder `__args__` expands to []Arg.
+type Arg struct {
+	Name  string
+	Value interface{}
+}
+
+// JoinPoint is the metadata of the function which an aspect applies to. `@inject` populates it
+// when the param is declared with type `inject.JoinPoint`, otherwise `@inject` populates AOPLabel.
+//
+// Func is the full name of function like `runtime.FuncForPC`, e.g. `orders.(*Service).Create`.
+// Package is the import path of the package, or the package name if the import path is unknown.
+// File is the file name joined with the import path, or the file name if the import path is unknown.
+// Line is the line of function in File.
+// Signature is the declaration of function without body, e.g. `func (s *Service) Create(id int) error`.
+// IDs are all the AOP ids of the function, from the outermost to the innermost.
+// Args are the arguments of the AOP id which declares the JoinPoint.
+// Start is the time when the function starts.
+type JoinPoint struct {
+	AOPLabel
+	Func      string
+	Package   string
+	File      string
+	Line      int
+	Signature string
+	IDs       []string
+	Args      map[string]interface{}
+	Start     time.Time
+}
+
+// Begin set the Start of jp to now, and return jp.
+func Begin(jp JoinPoint) JoinPoint {
+	jp.Start = time.Now()
+	return jp
+}
+
+// Elapsed returns the time since Start.
+func (jp JoinPoint) Elapsed() time.Duration {
+	return time.Since(jp.Start)
+}